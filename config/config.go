@@ -0,0 +1,131 @@
+// Package config loads and validates the exporter's configuration file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/benridley/wls_go/exporter"
+	"github.com/benridley/wls_go/web"
+	"gopkg.in/yaml.v2"
+)
+
+// Config represents the main application config
+type Config struct {
+	CertPath    string               `yaml:"tls_cert_path,omitempty"` // Certificate used for TLS, should include CA chain if its signed.
+	Keypath     string               `yaml:"tls_key_path,omitempty"`  // Private Key used for TLS
+	ListenPort  string               `yaml:"listen_port,omitempty"`   // Port used to listen for scrape requests
+	MBeans      *exporter.MbeanQuery `yaml:"mbeans,omitempty"`        // Queries of mBeans the exporter tries to scrape
+	Queries     *exporter.MbeanQuery `yaml:"queries,omitempty"`       // Older name for mbeans, still accepted
+	Options     exporter.Options     `yaml:",inline"`                 // Exporter wide settings such as label_conflict
+	StaleMaxAge time.Duration        `yaml:"stale_max_age,omitempty"` // How long to serve a target's last good metrics when it can't be probed. 0 disables this
+	Include     []string             `yaml:"include,omitempty"`       // Paths or globs of fragment files grafting children onto the mBean tree
+	Presets     []string             `yaml:"presets,omitempty"`       // Names of built in presets merged into the mBean tree
+	Web         web.Config           `yaml:"web,omitempty"`           // Authentication of requests to the exporter itself
+
+	// Credentials used to query Weblogic instead of those passed by the scraper. Required if web authentication is
+	// enabled, since the Authorization header then holds the credentials for the exporter rather than Weblogic.
+	WeblogicUsername string `yaml:"weblogic_username,omitempty"`
+	WeblogicPassword string `yaml:"weblogic_password,omitempty"`
+
+	Warnings []string `yaml:"-"` // Problems that didn't stop the config loading, such as settings that were left out
+}
+
+// defaultListenPort is the port the exporter listens on if listen_port isn't set.
+const defaultListenPort = "9325"
+
+// Load reads, expands variables in and parses the config file at path, merging presets and grafting included fragments
+// onto its mBean tree. Configs written for Oracle's WebLogic Monitoring Exporter are converted.
+func Load(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config file: %s", err.Error())
+	}
+	raw := data
+	data, err = expand(data, filepath.Dir(path))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %s", path, err.Error())
+	}
+	parse := Parse
+	if isOracleFormat(data) {
+		parse = ConvertOracle
+	}
+	config, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %s", path, expandedError(raw, data, err).Error())
+	}
+	if config.ListenPort == "" {
+		config.ListenPort = defaultListenPort
+	}
+	// Where each child added by a preset or fragment came from, keyed by its path, to explain conflicts
+	origins := make(map[string]string)
+	if err := config.applyPresets(origins); err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %s", path, err.Error())
+	}
+	if err := config.includeFragments(filepath.Dir(path), origins); err != nil {
+		return nil, err
+	}
+	// Included fragments may set presets on their mBeans too
+	if err := applyTreePresets(config.MBeans, "serverRuntime", nil, origins, &config.Warnings); err != nil {
+		return nil, fmt.Errorf("Invalid config file %s: %s", path, err.Error())
+	}
+	return config, nil
+}
+
+/*
+Parse parses a config strictly, so unknown or misplaced keys are reported with their line numbers rather than ignored.
+The mBean queries may be given under mbeans or queries, but not both.
+*/
+func Parse(data []byte) (*Config, error) {
+	config := Config{}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, cleanYAMLError(err)
+	}
+	if config.MBeans != nil && config.Queries != nil {
+		return nil, errors.New("Both mbeans and queries are set. Use mbeans only, queries is an older name for it")
+	}
+	if config.MBeans == nil {
+		config.MBeans = config.Queries
+		config.Queries = nil
+	}
+	if config.MBeans == nil && len(config.Presets) != 0 {
+		// Presets alone are enough, configuring children of an unlabelled serverRuntime
+		config.MBeans = &exporter.MbeanQuery{}
+	}
+	if config.MBeans == nil {
+		return nil, errors.New("No mBeans configured. Add them under mbeans or presets")
+	}
+	if config.StaleMaxAge < 0 {
+		return nil, fmt.Errorf("Invalid stale_max_age %s. Must not be negative", config.StaleMaxAge)
+	}
+	if err := config.Web.Validate(); err != nil {
+		return nil, err
+	}
+	if config.Web.ClientCAFile != "" && (config.CertPath == "" || config.Keypath == "") {
+		return nil, errors.New("web client_ca_file requires tls_cert_path and tls_key_path to be set")
+	}
+	if (config.WeblogicUsername == "") != (config.WeblogicPassword == "") {
+		return nil, errors.New("weblogic_username and weblogic_password must be set together")
+	}
+	// Otherwise anyone who can reach the exporter could have it send the credentials to a host of their choosing
+	if config.WeblogicUsername != "" && !config.Web.AuthEnabled() && config.Web.ClientCAFile == "" {
+		return nil, errors.New("weblogic_username and weblogic_password require web authentication, " +
+			"with basic_auth_users, bearer_tokens or client_ca_file")
+	}
+	if config.Web.AuthEnabled() && config.WeblogicUsername == "" {
+		return nil, errors.New("Web authentication requires weblogic_username and weblogic_password to be set, " +
+			"since the Authorization header no longer carries the Weblogic credentials")
+	}
+	return &config, nil
+}
+
+var unknownKeyRegex = regexp.MustCompile(`field (\S+) not found in type \S+`)
+
+// cleanYAMLError rewords errors for unknown keys, which otherwise name the Go types they were decoded into.
+func cleanYAMLError(err error) error {
+	return errors.New(unknownKeyRegex.ReplaceAllString(err.Error(), "unknown key $1"))
+}
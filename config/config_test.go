@@ -0,0 +1,354 @@
+package config
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/benridley/wls_go/exporter"
+	"gopkg.in/yaml.v2"
+)
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		config      string
+		errContains string
+	}{
+		{
+			name:   "mbeans",
+			config: "mbeans:\n  label_name: server\n  label_value_attribute: name\n  fields: [openSocketsCurrentCount]\n",
+		},
+		{
+			name:   "queries alias",
+			config: "queries:\n  label_name: server\n  label_value_attribute: name\n  fields: [openSocketsCurrentCount]\n",
+		},
+		{
+			name:   "presets only",
+			config: "presets: [jvm]\n",
+		},
+		{
+			name:        "mbeans and queries",
+			config:      "mbeans:\n  fields: [a]\nqueries:\n  fields: [b]\n",
+			errContains: "Both mbeans and queries are set",
+		},
+		{
+			name:        "no mbeans",
+			config:      "listen_port: 9325\n",
+			errContains: "No mBeans configured",
+		},
+		{
+			name:        "unknown key",
+			config:      "mbeans:\n  fields: [a]\n  children:\n    JVMRuntime:\n      feilds: [heapFreeCurrent]\n",
+			errContains: "line 5: unknown key feilds",
+		},
+		{
+			name:        "misplaced key",
+			config:      "mbeans:\n  fields: [a]\n  label_conflict: prefix\n",
+			errContains: "line 3: unknown key label_conflict",
+		},
+		{
+			name:        "label without attribute",
+			config:      "mbeans:\n  label_name: server\n  fields: [a]\n",
+			errContains: "Must provide label_value_attribute",
+		},
+		{
+			name:   "web authentication",
+			config: "mbeans:\n  fields: [a]\nweblogic_username: monitor\nweblogic_password: secret\nweb:\n  bearer_tokens: [abc]\n",
+		},
+		{
+			name:        "web authentication without weblogic credentials",
+			config:      "mbeans:\n  fields: [a]\nweb:\n  bearer_tokens: [abc]\n",
+			errContains: "Web authentication requires weblogic_username and weblogic_password",
+		},
+		{
+			name:        "basic auth password not hashed",
+			config:      "mbeans:\n  fields: [a]\nweblogic_username: monitor\nweblogic_password: secret\nweb:\n  basic_auth_users:\n    prometheus: secret\n",
+			errContains: "Password of basic auth user prometheus must be a bcrypt hash",
+		},
+		{
+			name:        "weblogic credentials without web authentication",
+			config:      "mbeans:\n  fields: [a]\nweblogic_username: monitor\nweblogic_password: secret\n",
+			errContains: "weblogic_username and weblogic_password require web authentication",
+		},
+		{
+			name:   "weblogic credentials with client certificates",
+			config: "tls_cert_path: server.crt\ntls_key_path: server.key\nmbeans:\n  fields: [a]\nweblogic_username: monitor\nweblogic_password: secret\nweb:\n  client_ca_file: ca.pem\n",
+		},
+		{
+			name:        "client CA without TLS",
+			config:      "mbeans:\n  fields: [a]\nweb:\n  client_ca_file: ca.pem\n",
+			errContains: "client_ca_file requires tls_cert_path and tls_key_path",
+		},
+	} {
+		config, err := Parse([]byte(tc.config))
+		if tc.errContains != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+				t.Errorf("%s: Want error containing %q, got %v", tc.name, tc.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: Unexpected error %v", tc.name, err)
+			continue
+		}
+		if config.MBeans == nil || config.Queries != nil {
+			t.Errorf("%s: Want mBeans under mbeans, got %+v", tc.name, config)
+		}
+	}
+}
+
+func TestInclude(t *testing.T) {
+	config, err := Load("testdata/include/config.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := config.MBeans.Children["JVMRuntime"]; !ok {
+		t.Errorf("Want JVMRuntime grafted onto serverRuntime, got %+v", config.MBeans.Children)
+	}
+	servlets := config.MBeans.Children["applicationRuntimes"].Children["componentRuntimes"].Children["servlets"]
+	if servlets.LabelName != "servlet" {
+		t.Errorf("Want servlets grafted onto applicationRuntimes, got %+v", config.MBeans.Children["applicationRuntimes"])
+	}
+
+	for _, tc := range []struct {
+		path        string
+		errContains string
+	}{
+		{path: "testdata/include/conflict.yml", errContains: "serverRuntime/JVMRuntime is already defined in testdata/include/conf.d/jvm.yml"},
+		{path: "testdata/include/missing_path.yml", errContains: "mBean serverRuntime has no child JDBCServiceRuntime"},
+		{path: "testdata/include/missing_file.yml", errContains: "Included file testdata/include/extra/nope.yml does not exist"},
+	} {
+		if _, err := Load(tc.path); err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Errorf("%s: Want error containing %q, got %v", tc.path, tc.errContains, err)
+		}
+	}
+}
+
+func TestPresets(t *testing.T) {
+	root := func() exporter.MbeanQuery {
+		return exporter.MbeanQuery{LabelName: "server", LabelValueAttribute: "name", Fields: []string{"openSocketsCurrentCount"}}
+	}
+	// Each preset must build on its own and together with every other preset
+	for _, names := range append([][]string{presetNames()}, splitNames(presetNames())...) {
+		mbeans := root()
+		config := Config{MBeans: &mbeans, Presets: names}
+		if err := config.applyPresets(make(map[string]string)); err != nil {
+			t.Errorf("%v: %s", names, err)
+			continue
+		}
+		if _, err := exporter.New(*config.MBeans, config.Options); err != nil {
+			t.Errorf("%v: %s", names, err)
+		}
+	}
+
+	mbeans := root()
+	mbeans.Children = map[string]exporter.MbeanQuery{
+		"JVMRuntime": {MetricPrefix: "jvm_", Fields: []string{"heapFreeCurrent", "processCpuLoad"}},
+	}
+	config := Config{MBeans: &mbeans, Presets: []string{"jvm"}}
+	if err := config.applyPresets(make(map[string]string)); err != nil {
+		t.Fatal(err)
+	}
+	jvm := config.MBeans.Children["JVMRuntime"]
+	wantFields := []string{"heapFreeCurrent", "processCpuLoad", "heapFreePercent", "heapSizeCurrent", "heapSizeMax", "uptime"}
+	if jvm.MetricPrefix != "jvm_" || !reflect.DeepEqual(wantFields, jvm.Fields) || jvm.FieldTypes["uptime"] != "duration_ms" {
+		t.Errorf("Want custom JVMRuntime merged with the jvm preset, got %+v", jvm)
+	}
+
+	config = Config{MBeans: &mbeans, Presets: []string{"jmx"}}
+	if err := config.applyPresets(make(map[string]string)); err == nil || !strings.Contains(err.Error(), "Unknown preset") {
+		t.Errorf("Want an unknown preset error, got %v", err)
+	}
+
+	// Presets can also be set on the mBeans they configure, alongside custom children
+	mbeans = root()
+	mbeans.Children = map[string]exporter.MbeanQuery{
+		"JDBCServiceRuntime": {Preset: "jdbc"},
+		"applicationRuntimes": {
+			Preset: "webapp",
+			Children: map[string]exporter.MbeanQuery{
+				"componentRuntimes": {Children: map[string]exporter.MbeanQuery{"servlets": {Fields: []string{"invocationTotalCount"}}}},
+			},
+		},
+	}
+	config = Config{MBeans: &mbeans}
+	if err := config.applyPresets(make(map[string]string)); err != nil {
+		t.Fatal(err)
+	}
+	datasources := config.MBeans.Children["JDBCServiceRuntime"].Children["JDBCDataSourceRuntimeMBeans"]
+	components := config.MBeans.Children["applicationRuntimes"].Children["componentRuntimes"]
+	if datasources.LabelName != "datasource" || components.LabelName != "component" || len(components.Children["servlets"].Fields) != 1 {
+		t.Errorf("Want the jdbc and webapp presets merged with custom children, got %+v", config.MBeans)
+	}
+	if _, err := exporter.New(*config.MBeans, config.Options); err != nil {
+		t.Error(err)
+	}
+
+	for _, tc := range []struct {
+		children    map[string]exporter.MbeanQuery
+		errContains string
+	}{
+		{children: map[string]exporter.MbeanQuery{"JVMRuntime": {Preset: "jdbc"}}, errContains: "Preset jdbc doesn't configure mBean serverRuntime/JVMRuntime"},
+		{children: map[string]exporter.MbeanQuery{"JVMRuntime": {Preset: "jmx"}}, errContains: "Invalid preset on mBean serverRuntime/JVMRuntime: Unknown preset"},
+	} {
+		mbeans = root()
+		mbeans.Children = tc.children
+		config = Config{MBeans: &mbeans}
+		if err := config.applyPresets(make(map[string]string)); err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Errorf("Want error containing %q, got %v", tc.errContains, err)
+		}
+	}
+}
+
+// splitNames returns each name in its own slice.
+func splitNames(names []string) [][]string {
+	split := make([][]string, 0, len(names))
+	for _, name := range names {
+		split = append(split, []string{name})
+	}
+	return split
+}
+
+func TestExpand(t *testing.T) {
+	os.Setenv("WLS_EXPORTER_TEST_PORT", "9400")
+	os.Setenv("WLS_EXPORTER_TEST_EMPTY", "")
+	os.Setenv("WLS_EXPORTER_TEST_HASH", "pa #ss")
+	os.Setenv("WLS_EXPORTER_TEST_COLON", "user: admin")
+	os.Setenv("WLS_EXPORTER_TEST_OCTAL", "0123")
+	for _, name := range []string{"PORT", "EMPTY", "HASH", "COLON", "OCTAL"} {
+		defer os.Unsetenv("WLS_EXPORTER_TEST_" + name)
+	}
+
+	for _, tc := range []struct {
+		input       string
+		want        interface{}
+		errContains string
+	}{
+		{input: "value: ${WLS_EXPORTER_TEST_PORT}", want: 9400},
+		{input: "value: ${WLS_EXPORTER_TEST_MISSING:-9325}", want: 9325},
+		{input: "value: ${WLS_EXPORTER_TEST_EMPTY:-9325}", want: 9325},
+		{input: "value: ${file:secret}", want: "server.key"},
+		{input: "value: cost_$$", want: "cost_$"},
+		{input: "value: port ${WLS_EXPORTER_TEST_PORT}", want: "port 9400"},
+		// Replaced values are never read as YAML
+		{input: "value: ${WLS_EXPORTER_TEST_HASH}", want: "pa #ss"},
+		{input: "value: ${WLS_EXPORTER_TEST_COLON}", want: "user: admin"},
+		{input: "value: ${WLS_EXPORTER_TEST_OCTAL}", want: "0123"},
+		{input: "value: ${file:multiline_secret}", want: "-----BEGIN SECRET-----\nab#c: d\n-----END SECRET-----"},
+		// Comments are left alone
+		{input: "# value: ${WLS_EXPORTER_TEST_MISSING}\nvalue: 1 # ${WLS_EXPORTER_TEST_MISSING}", want: 1},
+		{
+			input:       "value: 1\ntls_cert_path: ${WLS_EXPORTER_TEST_MISSING}\nmbeans:\n  fields:\n  - ${file:missing}",
+			errContains: "tls_cert_path: Environment variable WLS_EXPORTER_TEST_MISSING is not set\n  mbeans.fields[0]: Cannot read file",
+		},
+		{input: "value: ${9PORT}", errContains: `value: Invalid variable name "9PORT"`},
+	} {
+		got, err := expand([]byte(tc.input), "testdata")
+		if tc.errContains != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.errContains) {
+				t.Errorf("%q: Want error containing %q, got %v", tc.input, tc.errContains, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: Unexpected error %v", tc.input, err)
+			continue
+		}
+		var parsed struct {
+			Value interface{} `yaml:"value"`
+		}
+		if err := yaml.Unmarshal(got, &parsed); err != nil {
+			t.Errorf("%q: Invalid YAML %q: %v", tc.input, string(got), err)
+		} else if !reflect.DeepEqual(tc.want, parsed.Value) {
+			t.Errorf("%q: Want %#v, got %#v", tc.input, tc.want, parsed.Value)
+		}
+	}
+
+	// Configs without references are used as they are, so errors point at the right lines
+	input := "listen_port: 9325 # port\n"
+	if got, err := expand([]byte(input), "testdata"); err != nil || string(got) != input {
+		t.Errorf("%q: Want it unchanged, got %q %v", input, string(got), err)
+	}
+}
+
+func TestConvertOracle(t *testing.T) {
+	config, err := Load("testdata/oracle.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantWarnings := []string{
+		"The setting domainQualifier isn't supported, so was left out",
+		"The setting restPort isn't supported, so was left out",
+	}
+	if !reflect.DeepEqual(wantWarnings, config.Warnings) {
+		t.Errorf("Want warnings %v\nGot %v\n", wantWarnings, config.Warnings)
+	}
+	if config.Options.Naming.Case != "" || config.ListenPort != "9325" {
+		t.Errorf("Want snake case names and the default listen port, got %+v", config)
+	}
+
+	components := config.MBeans.Children["applicationRuntimes"].Children["componentRuntimes"]
+	if components.LabelName != "name" || components.MetricPrefix != "webapp_config_" ||
+		!reflect.DeepEqual(map[string]string{"type": "WebAppComponentRuntime"}, components.Include) {
+		t.Errorf("Want componentRuntimes labelled by name and filtered by type, got %+v", components)
+	}
+	datasources := config.MBeans.Children["JDBCServiceRuntime"].Children["JDBCDataSourceRuntimeMBeans"]
+	wantStringFields := []exporter.StringField{{Name: "state", ValueSet: []string{"Running", "Suspended", "Shutdown"}}}
+	if !reflect.DeepEqual([]string{"activeConnectionsCurrentCount"}, datasources.Fields) ||
+		!reflect.DeepEqual(wantStringFields, datasources.StringFields) ||
+		!reflect.DeepEqual(map[string]string{"name": "^Internal.*"}, datasources.Exclude) {
+		t.Errorf("Want datasources with state as a string field and internal ones excluded, got %+v", datasources)
+	}
+	if _, err := exporter.New(*config.MBeans, config.Options); err != nil {
+		t.Error(err)
+	}
+
+	for _, tc := range []struct {
+		config      string
+		errContains string
+	}{
+		{config: "queries:\n- JVMRuntime:\n    keyName: jvm\n    values: [uptime]\n", errContains: "Cannot use keyName on mBean serverRuntime/JVMRuntime without a key"},
+		{config: "queries:\n- JVMRuntime:\n    values: uptime\n", errContains: "Invalid values on mBean serverRuntime/JVMRuntime"},
+		{config: "queries:\n- JVMRuntime: {}\n", errContains: "mBean serverRuntime/JVMRuntime has no values or children"},
+	} {
+		if _, err := ConvertOracle([]byte(tc.config)); err == nil || !strings.Contains(err.Error(), tc.errContains) {
+			t.Errorf("%q: Want error containing %q, got %v", tc.config, tc.errContains, err)
+		}
+	}
+	// Queries for different types of item in the same collection can't both be kept, so the second one is reported
+	split := `
+queries:
+- applicationRuntimes:
+    key: name
+    componentRuntimes:
+      type: WebAppComponentRuntime
+      prefix: webapp_
+      key: name
+      values: [openSessionsCurrentCount]
+- applicationRuntimes:
+    key: name
+    componentRuntimes:
+      type: EJBComponentRuntime
+      prefix: ejb_
+      key: name
+      values: [deploymentState]
+`
+	config, err = ConvertOracle([]byte(split))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantWarnings = []string{
+		"mBean serverRuntime/applicationRuntimes/componentRuntimes in query 2 sets a different metric_prefix, which was ignored. " +
+			"Its fields and children were merged under the existing settings",
+		"mBean serverRuntime/applicationRuntimes/componentRuntimes in query 2 sets a different include, which was ignored. " +
+			"Its fields and children were merged under the existing settings",
+	}
+	if !reflect.DeepEqual(wantWarnings, config.Warnings) {
+		t.Errorf("Want warnings %v\nGot %v\n", wantWarnings, config.Warnings)
+	}
+	if config, err := ConvertOracle([]byte("queries:\n- JVMRuntime:\n    values: [uptime]\n")); err != nil || config.Options.Naming.Case != "camel" {
+		t.Errorf("Want camel case names by default, got %v %v", config, err)
+	}
+}
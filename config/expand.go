@@ -0,0 +1,148 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// variableRegex matches $$, an escaped dollar, and references like ${VAR}, ${VAR:-default} and ${file:/path}.
+var variableRegex = regexp.MustCompile(`\$\$|\$\{([^}]*)\}`)
+
+var variableNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+/*
+expand replaces references to environment variables and files in the string values of a config file. ${VAR} is
+replaced by the environment variable VAR, and ${VAR:-default} by the default if VAR is unset or empty. ${file:/path} is
+replaced by the contents of the file without trailing newlines, with relative paths relative to dir. $$ is replaced by
+a single $.
+
+The file is parsed before anything is replaced, so comments are left alone and a replaced value is never read as YAML,
+even if it contains characters like # or newlines. A value that's entirely a number or boolean after replacing, like
+a port, is typed as one so it can be used in numerical settings. The result is the config re-encoded as YAML, or data
+itself if it has no references. Every unset variable and unreadable file is reported, along with its key.
+*/
+func expand(data []byte, dir string) ([]byte, error) {
+	var tree yaml.MapSlice
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, cleanYAMLError(err)
+	}
+	e := expander{dir: dir}
+	e.expandValue("", tree)
+	if len(e.problems) != 0 {
+		return nil, errors.New("Cannot expand variables:\n" + strings.Join(e.problems, "\n"))
+	}
+	if !e.expanded {
+		return data, nil
+	}
+	return yaml.Marshal(tree)
+}
+
+// expandedError notes on an error parsing an expanded config that its line numbers are of the re-encoded config
+// rather than the file, if any variables were replaced.
+func expandedError(raw, expanded []byte, err error) error {
+	if bytes.Equal(raw, expanded) {
+		return err
+	}
+	return fmt.Errorf("%s (line numbers are of the config after expanding variables)", err.Error())
+}
+
+// expander replaces references in the values of a parsed config.
+type expander struct {
+	dir      string
+	expanded bool     // Whether any value had references
+	problems []string // Unset variables and unreadable files, with the keys referring to them
+}
+
+// expandValue replaces the references in a parsed value, and the values nested in it, returning the result. path is
+// the value's key, used to describe problems.
+func (e *expander) expandValue(path string, value interface{}) interface{} {
+	switch value := value.(type) {
+	case yaml.MapSlice:
+		for i, item := range value {
+			key := fmt.Sprint(item.Key)
+			if path != "" {
+				key = path + "." + key
+			}
+			value[i].Value = e.expandValue(key, item.Value)
+		}
+	case []interface{}:
+		for i, item := range value {
+			value[i] = e.expandValue(fmt.Sprintf("%s[%d]", path, i), item)
+		}
+	case string:
+		if !variableRegex.MatchString(value) {
+			return value
+		}
+		e.expanded = true
+		expanded := variableRegex.ReplaceAllStringFunc(value, func(ref string) string {
+			if ref == "$$" {
+				return "$"
+			}
+			resolved, err := resolveReference(ref[2:len(ref)-1], e.dir)
+			if err != nil {
+				e.problems = append(e.problems, fmt.Sprintf("  %s: %s", path, err.Error()))
+			}
+			return resolved
+		})
+		return typedScalar(expanded)
+	}
+	return value
+}
+
+// typedScalar returns a replaced value as a number or boolean if that's what it's written as, otherwise the string.
+// Values such as 0123 that would be written differently as a number are kept as strings, so secrets aren't changed.
+func typedScalar(value string) interface{} {
+	var typed interface{}
+	if err := yaml.Unmarshal([]byte(value), &typed); err != nil {
+		return value
+	}
+	switch typed.(type) {
+	case int, float64, bool:
+		if encoded, err := yaml.Marshal(typed); err == nil && strings.TrimSpace(string(encoded)) == value {
+			return typed
+		}
+	}
+	return value
+}
+
+// resolveReference returns the value of the contents of a ${...} reference.
+func resolveReference(ref, dir string) (string, error) {
+	if strings.HasPrefix(ref, "file:") {
+		path := strings.TrimPrefix(ref, "file:")
+		if path == "" {
+			return "", errors.New("${file:} must be given a path")
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("Cannot read file: %s", err.Error())
+		}
+		return strings.TrimRight(string(contents), "\r\n"), nil
+	}
+
+	name, defaultValue, hasDefault := ref, "", false
+	if i := strings.Index(ref, ":-"); i >= 0 {
+		name, defaultValue, hasDefault = ref[:i], ref[i+2:], true
+	}
+	if !variableNameRegex.MatchString(name) {
+		return "", fmt.Errorf("Invalid variable name %q in ${%s}", name, ref)
+	}
+	value, ok := os.LookupEnv(name)
+	if hasDefault && value == "" {
+		return defaultValue, nil
+	}
+	if !ok {
+		return "", fmt.Errorf("Environment variable %s is not set", name)
+	}
+	return value, nil
+}
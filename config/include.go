@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/benridley/wls_go/exporter"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+Fragment is a file included by the main config that grafts children onto the mBean tree, so parts of the tree can be
+owned by different teams. Path is the slash separated path of the mBean the children are added to, relative to
+serverRuntime, such as applicationRuntimes/componentRuntimes. An empty path adds them to serverRuntime itself.
+*/
+type Fragment struct {
+	Path     string                         `yaml:"path"`
+	Children map[string]exporter.MbeanQuery `yaml:"children"`
+}
+
+// includeFragments grafts the fragments matching the config's include patterns onto its mBean tree, in the order the
+// patterns are listed. Relative patterns are relative to dir, the directory of the main config file.
+func (c *Config) includeFragments(dir string, origins map[string]string) error {
+	for _, pattern := range c.Include {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return fmt.Errorf("Invalid include pattern %s: %s", pattern, err.Error())
+		}
+		// A glob matching nothing, such as an empty conf.d directory, is fine but a missing file isn't
+		if len(paths) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return fmt.Errorf("Included file %s does not exist", pattern)
+		}
+		for _, path := range paths {
+			if err := c.includeFragment(path, origins); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// includeFragment reads a fragment, expanding its variables, and grafts its children onto the mBean tree.
+func (c *Config) includeFragment(path string, origins map[string]string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Failed to read included file: %s", err.Error())
+	}
+	raw := data
+	data, err = expand(data, filepath.Dir(path))
+	if err != nil {
+		return fmt.Errorf("Invalid included file %s: %s", path, err.Error())
+	}
+	fragment := Fragment{}
+	if err := yaml.UnmarshalStrict(data, &fragment); err != nil {
+		return fmt.Errorf("Invalid included file %s: %s", path, expandedError(raw, data, cleanYAMLError(err)).Error())
+	}
+	if len(fragment.Children) == 0 {
+		return fmt.Errorf("Invalid included file %s: No children to add", path)
+	}
+	if err := graft(c.MBeans, fragment.Path, fragment.Children, path, origins); err != nil {
+		return fmt.Errorf("Cannot include %s: %s", path, err.Error())
+	}
+	return nil
+}
+
+// graft adds children to the mBean at beanPath in the tree rooted at q. The mBean must already exist, and mustn't
+// already have any of the children. source names where the children came from, which is recorded in origins so
+// later conflicts can say where a child was first defined.
+func graft(q *exporter.MbeanQuery, beanPath string, children map[string]exporter.MbeanQuery, source string, origins map[string]string) error {
+	var segments []string
+	if beanPath = strings.Trim(beanPath, "/"); beanPath != "" {
+		segments = strings.Split(beanPath, "/")
+	}
+	return graftAt(q, "serverRuntime", segments, children, source, origins)
+}
+
+// graftAt walks down the path segments from q, whose path is walked, and adds the children to the mBean at the end.
+func graftAt(q *exporter.MbeanQuery, walked string, segments []string, children map[string]exporter.MbeanQuery, source string, origins map[string]string) error {
+	if len(segments) > 0 {
+		child, ok := q.Children[segments[0]]
+		if !ok {
+			return fmt.Errorf("mBean %s has no child %s to add children to", walked, segments[0])
+		}
+		if err := graftAt(&child, walked+"/"+segments[0], segments[1:], children, source, origins); err != nil {
+			return err
+		}
+		q.Children[segments[0]] = child
+		return nil
+	}
+
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := q.Children[name]; ok {
+			origin, ok := origins[walked+"/"+name]
+			if !ok {
+				origin = "the main config"
+			}
+			return fmt.Errorf("%s/%s is already defined in %s", walked, name, origin)
+		}
+	}
+	if q.Children == nil {
+		q.Children = make(map[string]exporter.MbeanQuery, len(children))
+	}
+	for _, name := range names {
+		q.Children[name] = children[name]
+		origins[walked+"/"+name] = source
+	}
+	return nil
+}
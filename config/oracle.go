@@ -0,0 +1,219 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/benridley/wls_go/exporter"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+Configs written for Oracle's WebLogic Monitoring Exporter have a list of queries, each of which is an mBean tree from
+serverRuntime. Each mBean is a map of its settings and its children, where the settings are:
+
+	key: The attribute used to tell the items of a collection apart, like label_value_attribute
+	keyName: The label given the key, like label_name. Defaults to the key
+	prefix: Prepended to the mBean's metric names, like metric_prefix
+	values: The attributes to export, like fields
+	stringValues: A map of string attributes to their possible values, like string_fields
+	type: Only export items whose type attribute has this value
+	includedKeyValues, excludedKeyValues: Regexes of key values for the items to export or skip
+
+Any other map is a child mBean.
+*/
+
+// oracleSettings are the settings of an mBean in the Oracle format.
+var oracleSettings = map[string]bool{
+	"key": true, "keyName": true, "prefix": true, "values": true, "stringValues": true, "type": true,
+	"includedKeyValues": true, "excludedKeyValues": true,
+}
+
+// isOracleFormat reports whether a config file is written for Oracle's exporter, which has a list of queries rather
+// than a single tree.
+func isOracleFormat(data []byte) bool {
+	var config struct {
+		Queries interface{} `yaml:"queries"`
+	}
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return false
+	}
+	_, ok := config.Queries.([]interface{})
+	return ok
+}
+
+/*
+ConvertOracle converts a config written for Oracle's WebLogic Monitoring Exporter. Its queries are merged into a single
+mBean tree. Settings without an equivalent are left out, with a warning added to the config's Warnings. Metric names
+are kept in camel case unless the config sets metricsNameSnakeCase, so existing dashboards keep working.
+*/
+func ConvertOracle(data []byte) (*Config, error) {
+	var oracle map[string]interface{}
+	if err := yaml.Unmarshal(data, &oracle); err != nil {
+		return nil, err
+	}
+	config := &Config{MBeans: &exporter.MbeanQuery{}}
+	config.Options.Naming.Case = "camel"
+
+	keys := make([]string, 0, len(oracle))
+	for key := range oracle {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		switch key {
+		case "queries":
+		case "metricsNameSnakeCase":
+			if snakeCase, _ := oracle[key].(bool); snakeCase {
+				config.Options.Naming.Case = ""
+			}
+		default:
+			config.Warnings = append(config.Warnings, fmt.Sprintf("The setting %s isn't supported, so was left out", key))
+		}
+	}
+
+	queries, _ := oracle["queries"].([]interface{})
+	for i, query := range queries {
+		queryMap, ok := query.(map[interface{}]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Query %d must be a map of settings and mBeans", i+1)
+		}
+		q, err := convertOracleMBean("serverRuntime", queryMap, &config.Warnings)
+		if err != nil {
+			return nil, err
+		}
+		mergeQuery(config.MBeans, q, "serverRuntime", fmt.Sprintf("query %d", i+1), make(map[string]string), &config.Warnings)
+	}
+	return config, nil
+}
+
+// convertOracleMBean converts an mBean in the Oracle format, and its children. beanPath is used to describe problems.
+func convertOracleMBean(beanPath string, node map[interface{}]interface{}, warnings *[]string) (exporter.MbeanQuery, error) {
+	q := exporter.MbeanQuery{}
+	invalid := func(setting, want string) error {
+		return fmt.Errorf("Invalid %s on mBean %s. Must be %s", setting, beanPath, want)
+	}
+
+	key, ok := stringSetting(node, "key")
+	if !ok {
+		return q, invalid("key", "a string")
+	}
+	keyName, ok := stringSetting(node, "keyName")
+	if !ok {
+		return q, invalid("keyName", "a string")
+	}
+	if keyName != "" && key == "" {
+		return q, fmt.Errorf("Cannot use keyName on mBean %s without a key", beanPath)
+	}
+	if keyName == "" {
+		keyName = key
+	}
+	q.LabelName, q.LabelValueAttribute = keyName, key
+	if q.MetricPrefix, ok = stringSetting(node, "prefix"); !ok {
+		return q, invalid("prefix", "a string")
+	}
+
+	values, ok := stringListSetting(node, "values")
+	if !ok {
+		return q, invalid("values", "a list of attributes")
+	}
+	stringValues, _ := node["stringValues"].(map[interface{}]interface{})
+	if _, set := node["stringValues"]; set && stringValues == nil {
+		return q, invalid("stringValues", "a map of attributes to their values")
+	}
+	for _, value := range values {
+		if _, isString := stringValues[value]; !isString {
+			q.Fields = append(q.Fields, value)
+		}
+	}
+	for attribute := range stringValues {
+		name, _ := attribute.(string)
+		valueSet, ok := stringListSetting(stringValues, attribute)
+		if name == "" || !ok {
+			return q, invalid("stringValues", "a map of attributes to their values")
+		}
+		q.StringFields = append(q.StringFields, exporter.StringField{Name: name, ValueSet: valueSet})
+	}
+	sort.Slice(q.StringFields, func(i, j int) bool { return q.StringFields[i].Name < q.StringFields[j].Name })
+
+	if itemType, ok := stringSetting(node, "type"); !ok {
+		return q, invalid("type", "a string")
+	} else if itemType != "" {
+		q.Include = map[string]string{"type": regexp.QuoteMeta(itemType)}
+	}
+	for setting, filter := range map[string]*map[string]string{"includedKeyValues": &q.Include, "excludedKeyValues": &q.Exclude} {
+		patterns, ok := stringListSetting(node, setting)
+		if !ok {
+			return q, invalid(setting, "a list of regexes")
+		}
+		if len(patterns) == 0 {
+			continue
+		}
+		if key == "" {
+			return q, fmt.Errorf("Cannot use %s on mBean %s without a key", setting, beanPath)
+		}
+		if *filter == nil {
+			*filter = make(map[string]string)
+		}
+		(*filter)[key] = strings.Join(patterns, "|")
+	}
+
+	var childNames []string
+	for name := range node {
+		if childName, _ := name.(string); !oracleSettings[childName] {
+			childNames = append(childNames, childName)
+		}
+	}
+	sort.Strings(childNames)
+	for _, childName := range childNames {
+		childNode, ok := node[childName].(map[interface{}]interface{})
+		if !ok {
+			*warnings = append(*warnings, fmt.Sprintf("The setting %s on mBean %s isn't supported, so was left out", childName, beanPath))
+			continue
+		}
+		child, err := convertOracleMBean(beanPath+"/"+childName, childNode, warnings)
+		if err != nil {
+			return q, err
+		}
+		if q.Children == nil {
+			q.Children = make(map[string]exporter.MbeanQuery)
+		}
+		q.Children[childName] = child
+	}
+	if len(q.Fields) == 0 && len(q.StringFields) == 0 && len(q.Children) == 0 {
+		return q, errors.New("mBean " + beanPath + " has no values or children")
+	}
+	return q, nil
+}
+
+// stringSetting returns a string setting of an mBean, or false if it isn't a string.
+func stringSetting(node map[interface{}]interface{}, name string) (string, bool) {
+	value, ok := node[name]
+	if !ok {
+		return "", true
+	}
+	s, ok := value.(string)
+	return s, ok
+}
+
+// stringListSetting returns a setting of an mBean that's a list of strings, or false if it isn't one.
+func stringListSetting(node map[interface{}]interface{}, name interface{}) ([]string, bool) {
+	value, ok := node[name]
+	if !ok {
+		return nil, true
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	strs := make([]string, len(list))
+	for i, item := range list {
+		if strs[i], ok = item.(string); !ok {
+			return nil, false
+		}
+	}
+	return strs, true
+}
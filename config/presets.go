@@ -0,0 +1,289 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/benridley/wls_go/exporter"
+	"gopkg.in/yaml.v2"
+)
+
+/*
+presets are fragments for commonly monitored parts of the mBean tree, referenced by name under presets in config or
+with preset on an mBean. They're written for serverRuntime with a label_name of server, so their own labels avoid
+server and name.
+*/
+var presets = map[string]string{
+	"jvm": `
+children:
+    JVMRuntime:
+        metric_prefix: wls_jvm_
+        fields: [ heapFreeCurrent, heapFreePercent, heapSizeCurrent, heapSizeMax, uptime ]
+        field_types:
+            uptime: duration_ms
+`,
+	"threadpool": `
+children:
+    threadPoolRuntime:
+        metric_prefix: wls_threadpool_
+        fields: [ healthState, executeThreadTotalCount, executeThreadIdleCount, standbyThreadCount, hoggingThreadCount,
+                  stuckThreadCount, pendingUserRequestCount, queueLength, completedRequestCount, throughput ]
+`,
+	"jdbc": `
+children:
+    JDBCServiceRuntime:
+        children:
+            JDBCDataSourceRuntimeMBeans:
+                metric_prefix: wls_datasource_
+                label_name: datasource
+                label_value_attribute: name
+                fields: [ activeConnectionsCurrentCount, activeConnectionsHighCount, currCapacity,
+                          waitingForConnectionCurrentCount, waitSecondsHighCount, connectionsTotalCount,
+                          leakedConnectionCount, reserveRequestCount, failedReserveRequestCount ]
+                string_fields:
+                    - name: state
+                      value_set: [ Running, Suspended, Shutdown, Overloaded, Unknown ]
+                      unknown_values: other
+`,
+	"jms": `
+children:
+    JMSRuntime:
+        metric_prefix: wls_jms_
+        fields: [ healthState, connectionsCurrentCount, JMSServersCurrentCount ]
+        children:
+            JMSServers:
+                metric_prefix: wls_jms_server_
+                label_name: jms_server
+                label_value_attribute: name
+                fields: [ healthState, messagesCurrentCount, messagesPendingCount, messagesReceivedCount,
+                          bytesCurrentCount, bytesPendingCount, destinationsCurrentCount ]
+                children:
+                    destinations:
+                        metric_prefix: wls_jms_destination_
+                        label_name: destination
+                        label_value_attribute: name
+                        fields: [ messagesCurrentCount, messagesPendingCount, messagesReceivedCount,
+                                  consumersCurrentCount, bytesCurrentCount ]
+`,
+	"workmanager": `
+children:
+    workManagerRuntimes:
+        metric_prefix: wls_workmanager_
+        label_name: work_manager
+        label_value_attribute: name
+        fields: [ healthState, pendingRequests, completedRequests, stuckThreadCount ]
+`,
+	"webapp": `
+children:
+    applicationRuntimes:
+        label_name: application
+        label_value_attribute: name
+        children:
+            componentRuntimes:
+                metric_prefix: wls_webapp_
+                label_name: component
+                label_value_attribute: name
+                fields: [ deploymentState, openSessionsCurrentCount, openSessionsHighCount, sessionsOpenedTotalCount ]
+`,
+	"ejb": `
+children:
+    applicationRuntimes:
+        label_name: application
+        label_value_attribute: name
+        children:
+            componentRuntimes:
+                label_name: component
+                label_value_attribute: name
+                children:
+                    EJBRuntimes:
+                        label_name: ejb
+                        label_value_attribute: EJBName
+                        children:
+                            poolRuntime:
+                                metric_prefix: wls_ejb_pool_
+                                fields: [ accessTotalCount, beansInUseCurrentCount, pooledBeansCurrentCount,
+                                          waiterCurrentCount, timeoutTotalCount, destroyedTotalCount ]
+`,
+	"transactions": `
+children:
+    JTARuntime:
+        metric_prefix: wls_jta_
+        fields: [ healthState, activeTransactionsTotalCount, transactionTotalCount, transactionCommittedTotalCount,
+                  transactionRolledBackTotalCount, transactionHeuristicsTotalCount, transactionAbandonedTotalCount,
+                  secondsActiveTotalCount ]
+`,
+}
+
+// presetNames returns the names of the presets in order.
+func presetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// loadPreset parses the preset with the given name.
+func loadPreset(name string) (Fragment, error) {
+	preset, ok := presets[name]
+	if !ok {
+		return Fragment{}, fmt.Errorf("Unknown preset %q. Must be one of %s", name, strings.Join(presetNames(), ", "))
+	}
+	fragment := Fragment{}
+	if err := yaml.UnmarshalStrict([]byte(preset), &fragment); err != nil {
+		return Fragment{}, fmt.Errorf("Invalid preset %s: %s", name, cleanYAMLError(err).Error())
+	}
+	return fragment, nil
+}
+
+// applyPresets merges the config's presets, and those set on its mBeans, into its mBean tree. Origins records the paths
+// of the mBeans they add.
+func (c *Config) applyPresets(origins map[string]string) error {
+	for _, name := range c.Presets {
+		preset, err := loadPreset(name)
+		if err != nil {
+			return err
+		}
+		mergeChildren(c.MBeans, "serverRuntime", preset.Children, "preset "+name, origins, &c.Warnings)
+	}
+	return applyTreePresets(c.MBeans, "serverRuntime", nil, origins, &c.Warnings)
+}
+
+/*
+applyTreePresets merges the presets set with preset on the mBeans in the tree rooted at q, whose path is beanPath and
+whose path below serverRuntime is segments. A preset is merged from the mBean it's set on downwards, so it can be set
+on any mBean it configures, such as jdbc on JDBCServiceRuntime, or on serverRuntime to merge all of it.
+*/
+func applyTreePresets(q *exporter.MbeanQuery, beanPath string, segments []string, origins map[string]string, warnings *[]string) error {
+	if q.Preset != "" {
+		name := q.Preset
+		q.Preset = ""
+		preset, err := loadPreset(name)
+		if err != nil {
+			return fmt.Errorf("Invalid preset on mBean %s: %s", beanPath, err.Error())
+		}
+		node := exporter.MbeanQuery{Children: preset.Children}
+		for _, segment := range segments {
+			child, ok := node.Children[segment]
+			if !ok {
+				return fmt.Errorf("Preset %s doesn't configure mBean %s, so can't be set on it", name, beanPath)
+			}
+			node = child
+		}
+		mergeQuery(q, node, beanPath, "preset "+name, origins, warnings)
+	}
+	for name, child := range q.Children {
+		// Copy the segments so siblings don't share the same backing array
+		childSegments := append(append([]string(nil), segments...), name)
+		if err := applyTreePresets(&child, beanPath+"/"+name, childSegments, origins, warnings); err != nil {
+			return err
+		}
+		q.Children[name] = child
+	}
+	return nil
+}
+
+// mergeChildren merges children into the tree rooted at q, whose path is beanPath, so presets can be combined with
+// each other and custom config. Origins records the paths of the mBeans that are added.
+func mergeChildren(q *exporter.MbeanQuery, beanPath string, children map[string]exporter.MbeanQuery, source string,
+	origins map[string]string, warnings *[]string) {
+	if q.Children == nil {
+		q.Children = make(map[string]exporter.MbeanQuery, len(children))
+	}
+	for name, child := range children {
+		childPath := beanPath + "/" + name
+		existing, ok := q.Children[name]
+		if !ok {
+			q.Children[name] = child
+			origins[childPath] = source
+			continue
+		}
+		mergeQuery(&existing, child, childPath, source, origins, warnings)
+		q.Children[name] = existing
+	}
+}
+
+/*
+mergeQuery merges src into an mBean that's already in the tree. The existing settings are kept, and the fields, string
+fields, field types and children it doesn't have are added. Its label and metric prefix are only taken from src if it
+has none. Since an mBean has a single label, prefix and filter, a warning is added for each of these src sets
+differently, as its fields end up named and filtered by the existing ones.
+*/
+func mergeQuery(existing *exporter.MbeanQuery, src exporter.MbeanQuery, beanPath, source string, origins map[string]string,
+	warnings *[]string) {
+	ignored := func(setting string) {
+		*warnings = append(*warnings, fmt.Sprintf("mBean %s in %s sets a different %s, which was ignored. "+
+			"Its fields and children were merged under the existing settings", beanPath, source, setting))
+	}
+	if existing.LabelName == "" && existing.LabelValueAttribute == "" {
+		existing.LabelName, existing.LabelValueAttribute = src.LabelName, src.LabelValueAttribute
+	} else if (src.LabelName != "" || src.LabelValueAttribute != "") &&
+		(src.LabelName != existing.LabelName || src.LabelValueAttribute != existing.LabelValueAttribute) {
+		ignored("label")
+	}
+	if existing.MetricPrefix == "" {
+		existing.MetricPrefix = src.MetricPrefix
+	} else if src.MetricPrefix != "" && src.MetricPrefix != existing.MetricPrefix {
+		ignored("metric_prefix")
+	}
+	if !equalFilters(existing.Include, src.Include) {
+		ignored("include")
+	}
+	if !equalFilters(existing.Exclude, src.Exclude) {
+		ignored("exclude")
+	}
+	for _, field := range src.Fields {
+		if !containsString(existing.Fields, field) {
+			existing.Fields = append(existing.Fields, field)
+		}
+	}
+	for _, stringField := range src.StringFields {
+		if !hasStringField(existing.StringFields, stringField.Name) {
+			existing.StringFields = append(existing.StringFields, stringField)
+		}
+	}
+	for field, fieldType := range src.FieldTypes {
+		if existing.FieldTypes == nil {
+			existing.FieldTypes = make(map[string]string)
+		}
+		if _, ok := existing.FieldTypes[field]; !ok {
+			existing.FieldTypes[field] = fieldType
+		}
+	}
+	if len(src.Children) != 0 {
+		mergeChildren(existing, beanPath, src.Children, source, origins, warnings)
+	}
+}
+
+// equalFilters reports whether two include or exclude filters match the same items.
+func equalFilters(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for attribute, pattern := range a {
+		if other, ok := b[attribute]; !ok || other != pattern {
+			return false
+		}
+	}
+	return true
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasStringField(fields []exporter.StringField, name string) bool {
+	for _, field := range fields {
+		if field.Name == name {
+			return true
+		}
+	}
+	return false
+}
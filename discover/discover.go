@@ -0,0 +1,188 @@
+/*
+Package discover walks the mBean tree of a live Weblogic server through its REST API, recording the attributes and
+children of each mBean, so a starter config can be generated without reading the mBean reference by hand.
+*/
+package discover
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// itemSampleLimit is the number of items of each collection whose children are walked. Items of a collection usually
+// have the same children, so walking every item would only slow discovery down.
+const itemSampleLimit = 3
+
+// maxValueSet is the most distinct values of a string attribute that are suggested as its value set.
+const maxValueSet = 20
+
+// nonChildLinks are the rels of links in Weblogic's responses that don't lead to child mBeans.
+var nonChildLinks = map[string]bool{"self": true, "canonical": true, "parent": true, "action": true}
+
+// Bean records what was observed of an mBean, merged across every instance of it that was seen.
+type Bean struct {
+	Name       string
+	Collection bool   // Whether the mBean is a collection, such as applicationRuntimes
+	Items      int    // The number of items seen in the collection
+	Failed     string // Why the mBean couldn't be fetched, if it couldn't
+
+	numeric  map[string]bool
+	boolean  map[string]bool
+	strings  map[string]map[string]bool // Distinct values of each string attribute
+	objects  map[string]bool            // Object attributes such as healthState
+	arrays   map[string]bool
+	children map[string]*Bean
+}
+
+func newBean(name string) *Bean {
+	return &Bean{
+		Name:     name,
+		numeric:  make(map[string]bool),
+		boolean:  make(map[string]bool),
+		strings:  make(map[string]map[string]bool),
+		objects:  make(map[string]bool),
+		arrays:   make(map[string]bool),
+		children: make(map[string]*Bean),
+	}
+}
+
+// child returns the bean's child with the given name, creating it if it hasn't been seen yet.
+func (b *Bean) child(name string) *Bean {
+	child, ok := b.children[name]
+	if !ok {
+		child = newBean(name)
+		b.children[name] = child
+	}
+	return child
+}
+
+// Walker walks the mBean tree of a Weblogic server.
+type Walker struct {
+	Client   *http.Client
+	BaseURL  string // The server's URL, such as http://weblogic.mydomain.io:7001
+	Username string
+	Password string
+	Depth    int // How many levels of children below serverRuntime to walk
+}
+
+// Walk walks the tree from serverRuntime, returning what was observed.
+func (w *Walker) Walk() (*Bean, error) {
+	root := newBean("serverRuntime")
+	if err := w.walk(strings.TrimRight(w.BaseURL, "/")+"/management/weblogic/latest/serverRuntime", root, 0); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// walk fetches the mBean at beanURL, records it in bean and walks its children if level is above the depth.
+func (w *Walker) walk(beanURL string, bean *Bean, level int) error {
+	data, err := w.get(beanURL)
+	if err != nil {
+		return err
+	}
+	items, ok := data["items"].([]interface{})
+	if !ok {
+		bean.observe(data)
+		if level < w.Depth {
+			return w.walkChildren(beanURL, data, bean, level)
+		}
+		return nil
+	}
+
+	bean.Collection = true
+	bean.Items += len(items)
+	for i, item := range items {
+		itemData, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		bean.observe(itemData)
+		if level < w.Depth && i < itemSampleLimit {
+			name, ok := itemData["name"].(string)
+			if !ok {
+				continue
+			}
+			if err := w.walkChildren(beanURL+"/"+url.PathEscape(name), itemData, bean, level); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// walkChildren walks the children linked from an mBean's data. Children that can't be fetched, such as ones the user
+// isn't permitted to see, are recorded as failed rather than stopping the walk.
+func (w *Walker) walkChildren(beanURL string, data map[string]interface{}, bean *Bean, level int) error {
+	links, _ := data["links"].([]interface{})
+	for _, link := range links {
+		linkData, _ := link.(map[string]interface{})
+		rel, _ := linkData["rel"].(string)
+		if rel == "" || nonChildLinks[rel] {
+			continue
+		}
+		child := bean.child(rel)
+		if err := w.walk(beanURL+"/"+url.PathEscape(rel), child, level+1); err != nil {
+			child.Failed = err.Error()
+		}
+	}
+	return nil
+}
+
+// get fetches an mBean from the Weblogic API.
+func (w *Walker) get(beanURL string) (map[string]interface{}, error) {
+	req, err := http.NewRequest("GET", beanURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("X-Requested-By", "GoWlsClient")
+	req.Header.Add("accept", "application/json")
+	req.SetBasicAuth(w.Username, w.Password)
+
+	resp, err := w.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Weblogic API returned unexpected status %s for %s", resp.Status, beanURL)
+	}
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(body, &data); err != nil {
+		return nil, fmt.Errorf("Invalid Weblogic API response for %s: %s", beanURL, err.Error())
+	}
+	return data, nil
+}
+
+// observe records the attributes of an instance of the mBean.
+func (b *Bean) observe(data map[string]interface{}) {
+	for key, value := range data {
+		if key == "links" || key == "identity" {
+			continue
+		}
+		switch value := value.(type) {
+		case float64:
+			b.numeric[key] = true
+		case bool:
+			b.boolean[key] = true
+		case string:
+			if b.strings[key] == nil {
+				b.strings[key] = make(map[string]bool)
+			}
+			if len(b.strings[key]) <= maxValueSet {
+				b.strings[key][value] = true
+			}
+		case map[string]interface{}:
+			b.objects[key] = true
+		case []interface{}:
+			b.arrays[key] = true
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package discover
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/benridley/wls_go/config"
+	"github.com/benridley/wls_go/exporter"
+)
+
+var testResponses = map[string]string{
+	"/management/weblogic/latest/serverRuntime": `{"name":"admin-server","state":"RUNNING","openSocketsCurrentCount":3,
+		"healthState":{"state":"ok"},"links":[{"rel":"self","href":"x"},{"rel":"JVMRuntime","href":"x"},
+		{"rel":"applicationRuntimes","href":"x"},{"rel":"JMSRuntime","href":"x"}]}`,
+	"/management/weblogic/latest/serverRuntime/JVMRuntime": `{"name":"admin-server","heapFreeCurrent":1024,
+		"javaVendor":"Oracle","links":[{"rel":"parent","href":"x"}]}`,
+	"/management/weblogic/latest/serverRuntime/applicationRuntimes": `{"items":[
+		{"name":"app1","activeVersionState":2,"healthState":{"state":"ok"},"deploymentTargets":["admin"],"links":[{"rel":"componentRuntimes","href":"x"}]},
+		{"name":"app2","activeVersionState":2,"internal":true,"links":[]}]}`,
+	"/management/weblogic/latest/serverRuntime/applicationRuntimes/app1/componentRuntimes": `{"items":[
+		{"name":"app1_web","deploymentState":2,"status":"DEPLOYED"},{"name":"app1_ejb","deploymentState":2,"status":"PREPARED"}]}`,
+}
+
+func TestDiscover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "weblogic" || pass != "welcome1" {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		resp, ok := testResponses[r.URL.Path]
+		if !ok {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(resp))
+	}))
+	defer server.Close()
+
+	walker := Walker{Client: server.Client(), BaseURL: server.URL, Username: "weblogic", Password: "welcome1", Depth: 2}
+	root, err := walker.Walk()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := &bytes.Buffer{}
+	if err := root.WriteYAML(buf, "test"); err != nil {
+		t.Fatal(err)
+	}
+	skeleton := buf.String()
+	for _, want := range []string{
+		"    fields: [ openSocketsCurrentCount, healthState ]\n",
+		"    #     - name: state\n    #       value_set: [ \"RUNNING\" ]\n",
+		"            # A collection, with 2 items seen\n            label_name: application_runtimes\n",
+		"            # Booleans are exported as 1 for true and 0 for false: internal\n            fields: [ activeVersionState, internal, healthState ]\n",
+		"            # Arrays, which can be exported as their lengths by giving them the length field type: deploymentTargets\n",
+		"                    #       value_set: [ \"DEPLOYED\", \"PREPARED\" ]\n",
+		"        JMSRuntime:\n            # Could not be fetched: Weblogic API returned unexpected status 403 Forbidden",
+	} {
+		if !strings.Contains(skeleton, want) {
+			t.Errorf("Want skeleton containing\n%s\nGot\n%s", want, skeleton)
+		}
+	}
+
+	// The skeleton must be a valid config as it is
+	conf, err := config.Parse(buf.Bytes())
+	if err != nil {
+		t.Fatalf("%s\n%s", err, skeleton)
+	}
+	if _, err := exporter.New(*conf.MBeans, conf.Options); err != nil {
+		t.Fatalf("%s\n%s", err, skeleton)
+	}
+
+	walker.Password = "wrong"
+	if _, err := walker.Walk(); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("Want an unauthorized error, got %v", err)
+	}
+}
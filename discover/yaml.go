@@ -0,0 +1,125 @@
+package discover
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+)
+
+// labelAttribute is the attribute suggested to label the items of collections.
+const labelAttribute = "name"
+
+/*
+WriteYAML writes a commented config skeleton of the mbeans tree rooted at the bean. Numerical and boolean attributes
+are suggested as fields. String attributes, with their observed values as value sets, and arrays are left commented
+out as most are identifiers rather than states. source describes where the tree was discovered from.
+*/
+func (b *Bean) WriteYAML(w io.Writer, source string) error {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "# Generated by discovering the mBeans of %s.\n", source)
+	fmt.Fprintf(buf, "# Review the suggested fields, and uncomment any string fields and arrays worth exporting.\n")
+	fmt.Fprintf(buf, "mbeans:\n")
+	b.writeBody(buf, 1, "server")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// writeBody writes the settings and children of the bean at the given level of indentation. labelName is the label
+// suggested for the bean if it has a name attribute, or empty if it doesn't need a label.
+func (b *Bean) writeBody(buf *bytes.Buffer, level int, labelName string) {
+	indent := strings.Repeat("    ", level)
+	if b.Failed != "" {
+		fmt.Fprintf(buf, "%s# Could not be fetched: %s\n", indent, b.Failed)
+	}
+	if b.Collection {
+		fmt.Fprintf(buf, "%s# A collection, with %d items seen\n", indent, b.Items)
+	}
+	if _, ok := b.strings[labelAttribute]; ok && labelName != "" {
+		fmt.Fprintf(buf, "%slabel_name: %s\n", indent, labelName)
+		fmt.Fprintf(buf, "%slabel_value_attribute: %s\n", indent, labelAttribute)
+	} else if b.Collection {
+		fmt.Fprintf(buf, "%s# Set label_name and label_value_attribute to an attribute that tells the items apart\n", indent)
+	}
+
+	fields := sortedKeys(b.numeric)
+	booleans := sortedKeys(b.boolean)
+	fields = append(fields, booleans...)
+	// healthState is the only object attribute the exporter understands
+	if b.objects["healthState"] {
+		fields = append(fields, "healthState")
+	}
+	if len(booleans) != 0 {
+		fmt.Fprintf(buf, "%s# Booleans are exported as 1 for true and 0 for false: %s\n", indent, strings.Join(booleans, ", "))
+	}
+	if len(fields) != 0 {
+		fmt.Fprintf(buf, "%sfields: [ %s ]\n", indent, strings.Join(fields, ", "))
+	}
+
+	var stringFields []string
+	for _, name := range sortedKeys(b.strings) {
+		if name != labelAttribute {
+			stringFields = append(stringFields, name)
+		}
+	}
+	if len(stringFields) != 0 {
+		fmt.Fprintf(buf, "%s# string_fields:\n", indent)
+		for _, name := range stringFields {
+			fmt.Fprintf(buf, "%s#     - name: %s\n", indent, name)
+			values := sortedKeys(b.strings[name])
+			if len(values) > maxValueSet {
+				fmt.Fprintf(buf, "%s#       # More than %d values were seen, so it's probably not a state\n", indent, maxValueSet)
+				continue
+			}
+			quoted := make([]string, len(values))
+			for i, value := range values {
+				// JSON strings are valid YAML double quoted strings
+				q, _ := json.Marshal(value)
+				quoted[i] = string(q)
+			}
+			fmt.Fprintf(buf, "%s#       value_set: [ %s ]\n", indent, strings.Join(quoted, ", "))
+		}
+	}
+	if arrays := sortedKeys(b.arrays); len(arrays) != 0 {
+		fmt.Fprintf(buf, "%s# Arrays, which can be exported as their lengths by giving them the length field type: %s\n", indent, strings.Join(arrays, ", "))
+	}
+
+	if len(b.children) == 0 {
+		return
+	}
+	fmt.Fprintf(buf, "%schildren:\n", indent)
+	for _, name := range sortedKeys(b.children) {
+		fmt.Fprintf(buf, "%s    %s:\n", indent, name)
+		child := b.children[name]
+		childLabelName := ""
+		if child.Collection {
+			childLabelName = strcase.ToSnake(name)
+		}
+		child.writeBody(buf, level+2, childLabelName)
+	}
+}
+
+// sortedKeys returns the keys of a map with string keys in order.
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[string]bool:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]map[string]bool:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*Bean:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
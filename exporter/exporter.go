@@ -6,7 +6,11 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/benridley/wls_go/wls"
@@ -23,14 +27,38 @@ type Exporter struct {
 	configMap   MBeanConfigMap   // A map of the form <mBeanName, mBeanConfig> for mapping mbeans to labels and metric prefixes
 	client      http.Client      // The client used to perform the probing against the Weblogic API
 	query       wls.WLSRestQuery // Stores the query required by the exporter to prevent having to recreate it every time
+	groups      []refreshGroup   // Parts of the query that are fetched separately, according to their refresh intervals
+	responses   *responseCache   // Responses of groups with refresh intervals. A pointer so copies of the exporter share it
+	// Logs fields missing from Weblogic's responses. A pointer so copies of the exporter share the same throttling
+	missingFieldLog *throttledLog
+	// Logs series dropped for duplicating another item's, in collections whose items can't be told apart
+	duplicateSeriesLog *throttledLog
 }
 
 // MBeanConfig contains the data from config needed to create prometheus metrics from raw mBean data
 type MBeanConfig struct {
-	LabelName           string          // The label to use for this mBean when converting to Prometheus metrics
-	LabelValueAttribute string          // Which attribute of the mBean to use as the label's value
-	MetricPrefix        string          // An optional prefix to add to the resultant metrics for organising metrics
-	StringFieldInfo     stringFieldInfo // A set that contains mBean attributes which return strings. Used to enumerate all possible labels and provide consistent metrics
+	LabelName           string               // The label to use for this mBean when converting to Prometheus metrics
+	LabelValueAttribute string               // Which attribute of the mBean to use as the label's value
+	LabelReplaced       bool                 // Whether a descendant replaces the mBean's label under label_conflict: child_wins
+	MetricPrefix        string               // An optional prefix to add to the resultant metrics for organising metrics
+	StringFieldInfo     stringFieldInfo      // A set that contains mBean attributes which return strings. Used to enumerate all possible labels and provide consistent metrics
+	Include             itemFilter           // Items of a collection must match all of these attribute regexes to be exported
+	Exclude             itemFilter           // Items of a collection matching any of these attribute regexes are skipped
+	MaxItems            int                  // The maximum number of collection items to export, 0 means unlimited
+	SortBy              string               // Numerical attribute used to rank items when MaxItems is exceeded. Highest values are kept
+	OtherBucket         bool                 // Whether dropped items should be summed into a single item labelled "other"
+	AllFields           bool                 // Whether every attribute of the mBean was requested with the "*" wildcard
+	ExcludeFields       map[string]bool      // A set of numerical attributes that shouldn't be exported. Used alongside the wildcard
+	HiddenFields        map[string]bool      // A set of attributes that are fetched only to compute other metrics, so aren't exported
+	Derived             []derivedMetric      // Metrics computed from the mBean's numerical attributes
+	Aggregations        []Aggregation        // Metrics rolled up across the items of a collection
+	AggregateOnly       bool                 // Whether only the rolled up metrics are exported rather than the items themselves
+	Info                InfoMetric           // An optional info metric labelled with identity attributes of the mBean
+	FieldTypes          map[string]string    // Types of attributes that need converting, such as epoch millisecond timestamps
+	TimestampAge        bool                 // Whether to also export the seconds elapsed since each timestamp attribute
+	UnknownValues       map[string]string    // The policy for each string attribute's values that aren't in its value set
+	AbsentValues        map[string][]float64 // Sentinel values for each attribute that mean it has no value, such as -1
+	Naming              Naming               // How metric names are built, shared across the whole mBean tree
 }
 
 // MBeanConfigMap is a map of the form <MbeanName, MBeanConfig> so the exporter knows which labels and prefixes to use
@@ -45,6 +73,8 @@ type WeblogicAPIResponse struct {
 	NumericalFields map[string]float64
 	StringFields    map[string]string
 	ObjectFields    map[string]interface{}
+	ArrayLengths    map[string]int  // Lengths of attributes that are arrays of plain values rather than collections
+	NullFields      map[string]bool // Attributes returned as null
 	Children        map[string]*WeblogicAPIResponse
 }
 
@@ -56,10 +86,50 @@ series metrics. It's not intended to retrieve arbitrary strings, but rather thin
 like health states and deployment states that have known potential values.
 */
 type StringField struct {
-	Name     string   `yaml:"name,omitempty"`
-	ValueSet []string `yaml:"value_set,omitempty"`
+	Name          string   `yaml:"name,omitempty"`
+	ValueSet      []string `yaml:"value_set,omitempty"`
+	UnknownValues string   `yaml:"unknown_values,omitempty"` // What to do with values not in the value set: ignore, other, label or count
 }
 
+// DerivedMetric is a metric computed from an arithmetic expression over an mBean's numerical attributes,
+// for example heapSizeCurrent - heapFreeCurrent.
+type DerivedMetric struct {
+	Name string `yaml:"name,omitempty"`
+	Expr string `yaml:"expr,omitempty"`
+}
+
+/*
+Aggregation rolls a numerical attribute up across the items of a collection mBean, such as the total invocations across
+all servlets of a component. Op is one of sum, min, max, avg or count. Field may be left empty for count, which then
+counts all items rather than those with the attribute. Name optionally overrides the generated metric name, which for a
+count without a field is the collection's name followed by "count".
+*/
+type Aggregation struct {
+	Field string `yaml:"field,omitempty"`
+	Op    string `yaml:"op,omitempty"`
+	Name  string `yaml:"name,omitempty"`
+}
+
+/*
+InfoMetric is a metric with a constant value of 1, labelled with open-ended identity attributes of an mBean such as
+weblogicVersion or listenAddress. Unlike StringFields, the possible values don't need to be known in advance.
+Each label is named after its attribute in snake case. Name defaults to the mBean's metric prefix followed by "info", or
+weblogic_<mBean>_info if it has no prefix.
+*/
+type InfoMetric struct {
+	Name   string   `yaml:"name,omitempty"`
+	Fields []string `yaml:"fields,omitempty"`
+}
+
+// derivedMetric is a DerivedMetric with its expression parsed and ready to evaluate.
+type derivedMetric struct {
+	name string
+	expr expression
+}
+
+// itemFilter maps an mBean string attribute to the regex its value is matched against when filtering collection items.
+type itemFilter map[string]*regexp.Regexp
+
 // stringFieldInfo represnts the possible states of a string mBean attribute, converted from StringFields found in config.
 // Used a set with labels set to true to indicate their presence.
 type stringFieldInfo map[string]map[string]bool
@@ -68,8 +138,24 @@ type stringFieldInfo map[string]map[string]bool
 MbeanQuery is the configuration for each desired mbean.
 LabelName: This is an optional field that determines the name of the label on the outgoing metric
 LabelValueAttribute: Which mbean attribute should be queried for the LabelName value
-Fields: Desired attirbutes that return numerical data
+Fields: Desired attirbutes that return numerical data. A single "*" requests every attribute, exporting all numerical and boolean ones
+ExcludeFields: Numerical attributes that shouldn't be exported, for use with the "*" wildcard
 StringFields: Desired attributes that return a string. These will be converted to labels with 1 as the current state, 0 as other states.
+Include: Map of string attribute to regex. Collection items are only exported if every attribute matches its regex
+Exclude: Map of string attribute to regex. Collection items are skipped if any attribute matches its regex
+MaxItems: The maximum number of collection items to export. Any further items are dropped
+SortBy: Numerical attribute used to choose which items are kept when MaxItems is exceeded. Items with the highest values are kept
+OtherBucket: Sum the numerical fields of dropped items into a single item whose label value is "other"
+Derived: Metrics computed from arithmetic expressions over the mBean's numerical attributes. Attributes only used here are fetched but not exported
+Aggregate: Metrics rolled up across the items of a collection mBean, labelled with the parent mBean's labels
+AggregateOnly: Only export the rolled up metrics of a collection rather than its items
+Info: An info metric with a value of 1, labelled with the given attributes. Used for identity attributes like versions
+FieldTypes: Map of attribute to type for attributes that need converting. timestamp_ms and duration_ms are exported in seconds,
+and arrays typed as length are exported as their length. Strings typed as number, bytes, duration or bool are parsed into numbers
+AbsentValues: Map of attribute to sentinel values, such as -1, that mean the attribute has no value so shouldn't be exported
+TimestampAge: Also export the seconds elapsed since each timestamp_ms attribute, named with a _seconds_ago suffix
+RefreshInterval: Fetch the mBean and its children separately, reusing the response until the interval has passed
+Preset: Name of a built in preset merged into the mBean and its children. Presets are applied when the config is loaded
 Children: Child mbeans to also be queried
 */
 type MbeanQuery struct {
@@ -77,40 +163,437 @@ type MbeanQuery struct {
 	LabelValueAttribute string                `yaml:"label_value_attribute,omitempty"`
 	MetricPrefix        string                `yaml:"metric_prefix,omitempty"`
 	Fields              []string              `yaml:"fields,omitempty"`
+	ExcludeFields       []string              `yaml:"exclude_fields,omitempty"`
 	StringFields        []StringField         `yaml:"string_fields,omitempty"`
+	Include             map[string]string     `yaml:"include,omitempty"`
+	Exclude             map[string]string     `yaml:"exclude,omitempty"`
+	MaxItems            int                   `yaml:"max_items,omitempty"`
+	SortBy              string                `yaml:"sort_by,omitempty"`
+	OtherBucket         bool                  `yaml:"other_bucket,omitempty"`
+	Derived             []DerivedMetric       `yaml:"derived,omitempty"`
+	Aggregate           []Aggregation         `yaml:"aggregate,omitempty"`
+	AggregateOnly       bool                  `yaml:"aggregate_only,omitempty"`
+	Info                InfoMetric            `yaml:"info,omitempty"`
+	FieldTypes          map[string]string     `yaml:"field_types,omitempty"`
+	TimestampAge        bool                  `yaml:"timestamp_age,omitempty"`
+	AbsentValues        map[string][]float64  `yaml:"absent_values,omitempty"`
+	RefreshInterval     time.Duration         `yaml:"refresh_interval,omitempty"`
+	Preset              string                `yaml:"preset,omitempty"`
 	Children            map[string]MbeanQuery `yaml:"children,omitempty"`
 }
 
-// Populates a map to easily retrieve each mBean's monitoring config, such as label prefixes and label names
-func (cm MBeanConfigMap) createConfigMap(beanName string, q *MbeanQuery) {
+// Populates a map to easily retrieve each mBean's monitoring config, such as label prefixes and label names.
+// ancestorLabels maps the label names used by the mBean's ancestors to the mBean using them, to detect conflicts.
+func (cm MBeanConfigMap) createConfigMap(beanName string, q *MbeanQuery, opts Options, ancestorLabels map[string]string) error {
+	labelName, err := opts.resolveLabelName(beanName, q.LabelName, ancestorLabels)
+	if err != nil {
+		return err
+	}
+	include, err := newItemFilter(beanName, q.Include)
+	if err != nil {
+		return err
+	}
+	exclude, err := newItemFilter(beanName, q.Exclude)
+	if err != nil {
+		return err
+	}
+	if err := validateNames(beanName, labelName, q); err != nil {
+		return err
+	}
+	if q.RefreshInterval < 0 {
+		return fmt.Errorf("Invalid refresh_interval %s on mBean %s. Must not be negative", q.RefreshInterval, beanName)
+	}
+	if q.Preset != "" {
+		return fmt.Errorf("Preset %s on mBean %s was not applied. Presets are only supported in config files", q.Preset, beanName)
+	}
+	if q.MaxItems < 0 {
+		return fmt.Errorf("Invalid max_items %d on mBean %s. Must not be negative", q.MaxItems, beanName)
+	}
+	if q.MaxItems == 0 && (q.SortBy != "" || q.OtherBucket) {
+		return fmt.Errorf("Cannot use sort_by or other_bucket on mBean %s without max_items", beanName)
+	}
+	if q.OtherBucket && q.LabelName == "" {
+		return fmt.Errorf("Cannot use other_bucket on mBean %s without a label_name to identify the bucket", beanName)
+	}
 	beanConfig := MBeanConfig{
-		LabelName:           q.LabelName,
+		LabelName:           labelName,
 		LabelValueAttribute: q.LabelValueAttribute,
 		MetricPrefix:        q.MetricPrefix,
 		StringFieldInfo:     make(stringFieldInfo),
+		Include:             include,
+		Exclude:             exclude,
+		MaxItems:            q.MaxItems,
+		SortBy:              q.SortBy,
+		OtherBucket:         q.OtherBucket,
+		AllFields:           q.allFields(),
+		ExcludeFields:       make(map[string]bool),
+		HiddenFields:        make(map[string]bool),
+		UnknownValues:       make(map[string]string),
+		Naming:              opts.Naming,
+	}
+	for _, field := range q.ExcludeFields {
+		beanConfig.ExcludeFields[field] = true
+	}
+	for _, field := range q.internalFields() {
+		beanConfig.HiddenFields[field] = true
+	}
+	for _, a := range q.Aggregate {
+		switch a.Op {
+		case "sum", "min", "max", "avg":
+			if a.Field == "" {
+				return fmt.Errorf("Aggregation %s on mBean %s must have a field", a.Op, beanName)
+			}
+		case "count":
+		default:
+			return fmt.Errorf("Unknown aggregation %q on mBean %s. Must be one of sum, min, max, avg or count", a.Op, beanName)
+		}
+	}
+	beanConfig.Aggregations = q.Aggregate
+	beanConfig.AggregateOnly = q.AggregateOnly
+	beanConfig.Info = q.Info
+	if err := validateFieldTypes(beanName, q.FieldTypes); err != nil {
+		return err
+	}
+	beanConfig.FieldTypes = q.FieldTypes
+	beanConfig.TimestampAge = q.TimestampAge
+	beanConfig.AbsentValues = q.AbsentValues
+	if len(q.Info.Fields) == 0 && q.Info.Name != "" {
+		return fmt.Errorf("Info metric %s on mBean %s must have fields", q.Info.Name, beanName)
+	}
+	if err := validateInfoLabels(beanName, labelName, q.Info, ancestorLabels); err != nil {
+		return err
+	}
+	for _, d := range q.Derived {
+		if d.Name == "" {
+			return fmt.Errorf("Derived metric on mBean %s must have a name", beanName)
+		}
+		expr, err := parseExpression(d.Expr)
+		if err != nil {
+			return fmt.Errorf("Cannot parse derived metric %s on mBean %s: %s", d.Name, beanName, err.Error())
+		}
+		beanConfig.Derived = append(beanConfig.Derived, derivedMetric{name: d.Name, expr: expr})
 	}
 	cm[beanName] = beanConfig
+	if ancestor, ok := ancestorLabels[labelName]; ok && opts.LabelConflict == labelConflictChildWins {
+		ancestorConfig := cm[ancestor]
+		ancestorConfig.LabelReplaced = true
+		cm[ancestor] = ancestorConfig
+	}
 	for _, stringField := range q.StringFields {
+		if err := validateUnknownValuesPolicy(beanName, stringField); err != nil {
+			return err
+		}
+		beanConfig.UnknownValues[stringField.Name] = stringField.UnknownValues
 		beanConfig.StringFieldInfo[stringField.Name] = make(map[string]bool)
 		for _, value := range stringField.ValueSet {
 			beanConfig.StringFieldInfo[stringField.Name][value] = true
 		}
 	}
 	if q.Children == nil {
-		return
+		return nil
+	}
+	childAncestorLabels := make(map[string]string, len(ancestorLabels)+1)
+	for ancestorLabel, ancestor := range ancestorLabels {
+		childAncestorLabels[ancestorLabel] = ancestor
+	}
+	if labelName != "" {
+		childAncestorLabels[labelName] = beanName
 	}
 	for childName, childConfig := range q.Children {
-		cm.createConfigMap(childName, &childConfig)
+		if err := cm.createConfigMap(childName, &childConfig, opts, childAncestorLabels); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// infoName returns the name of the mBean's info metric.
+func (c MBeanConfig) infoName(beanName string) string {
+	if c.Info.Name != "" {
+		return c.Naming.withNamespace(c.Info.Name)
+	}
+	return c.beanMetricName(beanName, "info")
+}
+
+// validateInfoLabels checks the labels of an info metric don't clash with each other, or with the labels of the mBean
+// and its ancestors that the metric also carries.
+func validateInfoLabels(beanName, labelName string, info InfoMetric, ancestorLabels map[string]string) error {
+	infoLabels := make(map[string]string, len(info.Fields))
+	for _, field := range info.Fields {
+		label := strcase.ToSnake(field)
+		if label == labelName {
+			return fmt.Errorf("Info field %s on mBean %s clashes with the mBean's label %s", field, beanName, labelName)
+		}
+		if ancestor, ok := ancestorLabels[label]; ok {
+			return fmt.Errorf("Info field %s on mBean %s clashes with the label %s of mBean %s", field, beanName, label, ancestor)
+		}
+		if other, ok := infoLabels[label]; ok {
+			return fmt.Errorf("Info fields %s and %s on mBean %s are both labelled %s", other, field, beanName, label)
+		}
+		infoLabels[label] = field
+	}
+	return nil
+}
+
+/*
+validateBeanMetricNames checks the info and aggregation metrics of different mBeans don't share a name. The mBeans are
+labelled differently, so they can't be exported under one name. Attribute metrics aren't checked as their names depend
+on the attributes Weblogic returns.
+*/
+func (cm MBeanConfigMap) validateBeanMetricNames() error {
+	beanNames := make([]string, 0, len(cm))
+	for beanName := range cm {
+		beanNames = append(beanNames, beanName)
+	}
+	sort.Strings(beanNames)
+	owners := make(map[string]string)
+	claim := func(name, beanName string) error {
+		if owner, ok := owners[name]; ok {
+			if owner == beanName {
+				return fmt.Errorf("Metric %s is defined twice on mBean %s", name, beanName)
+			}
+			return fmt.Errorf("Metric %s is defined on both mBean %s and mBean %s. Set a name or metric_prefix to tell them apart", name, owner, beanName)
+		}
+		owners[name] = beanName
+		return nil
+	}
+	for _, beanName := range beanNames {
+		c := cm[beanName]
+		if len(c.Info.Fields) != 0 {
+			if err := claim(c.infoName(beanName), beanName); err != nil {
+				return err
+			}
+		}
+		for _, a := range c.Aggregations {
+			if err := claim(a.metricName(beanName, c), beanName); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// allFieldsWildcard is used in place of field names to request every attribute of an mBean
+const allFieldsWildcard = "*"
+
+// validateNames checks the label and metric names given to an mBean are valid in Prometheus, so a bad name fails
+// when the config is loaded rather than on every probe.
+func validateNames(beanName, labelName string, q *MbeanQuery) error {
+	if labelName != "" && !labelNameRegex.MatchString(labelName) {
+		return fmt.Errorf("Invalid label_name %q on mBean %s. Must only contain letters, digits and underscores, and not start with a digit", labelName, beanName)
+	}
+	metricNames := [][2]string{{"metric_prefix", q.MetricPrefix}, {"info name", q.Info.Name}}
+	for _, d := range q.Derived {
+		metricNames = append(metricNames, [2]string{"derived metric name", d.Name})
+	}
+	for _, a := range q.Aggregate {
+		metricNames = append(metricNames, [2]string{"aggregation name", a.Name})
+	}
+	for _, name := range metricNames {
+		if name[1] != "" && !metricNameRegex.MatchString(name[1]) {
+			return fmt.Errorf("Invalid %s %q on mBean %s. Must only contain letters, digits, underscores and colons, and not start with a digit", name[0], name[1], beanName)
+		}
+	}
+	return nil
+}
+
+// allFields reports whether the query requests every attribute of the mBean using the wildcard.
+func (q *MbeanQuery) allFields() bool {
+	return stringInSlice(allFieldsWildcard, q.Fields)
+}
+
+// internalFields returns the attributes that are fetched only to compute other metrics, such as those used by
+// sort_by, info and derived metrics, so that they can be requested without being exported.
+func (q *MbeanQuery) internalFields() []string {
+	if q.allFields() {
+		return nil
+	}
+	var used []string
+	if q.SortBy != "" {
+		used = append(used, q.SortBy)
+	}
+	for _, d := range q.Derived {
+		// Invalid expressions are reported when creating the config map
+		if expr, err := parseExpression(d.Expr); err == nil {
+			used = expr.fields(used)
+		}
+	}
+	for _, a := range q.Aggregate {
+		if a.Field != "" && !stringInSlice(a.Field, used) {
+			used = append(used, a.Field)
+		}
+	}
+	for _, field := range q.Info.Fields {
+		if !stringInSlice(field, used) {
+			used = append(used, field)
+		}
+	}
+	var internal []string
+	for _, field := range used {
+		if !stringInSlice(field, q.Fields) && !stringInSlice(field, internal) {
+			internal = append(internal, field)
+		}
+	}
+	return internal
+}
+
+// newItemFilter compiles the attribute regexes of an include or exclude filter. Regexes are anchored
+// so they must match the entire attribute value.
+func newItemFilter(beanName string, patterns map[string]string) (itemFilter, error) {
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	filter := make(itemFilter, len(patterns))
+	for attribute, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid filter regex for attribute %s on mBean %s: %s", attribute, beanName, err.Error())
+		}
+		filter[attribute] = re
+	}
+	return filter, nil
+}
+
+// includesItem reports whether a collection item passes the mBean's include and exclude filters.
+// Items missing an attribute used by an include filter are skipped.
+func (c MBeanConfig) includesItem(item *WeblogicAPIResponse) bool {
+	for attribute, re := range c.Include {
+		value, ok := item.StringFields[attribute]
+		if !ok || !re.MatchString(value) {
+			return false
+		}
+	}
+	for attribute, re := range c.Exclude {
+		if value, ok := item.StringFields[attribute]; ok && re.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// otherLabelValue is the label value given to the item that dropped collection items are summed into, and to the
+// series representing unknown string values.
+const otherLabelValue = "other"
+
+// ItemsDropped counts the collection items dropped by max_items limits. It should be registered with the exporter's own
+// registry.
+var ItemsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "weblogic_exporter_items_dropped_total",
+	Help: "Number of collection items dropped by the max_items limit",
+}, []string{"mbean"})
+
+// limitItems applies the mBean's max_items limit to a collection, keeping the items with the highest sort_by value.
+// Items without the sort_by attribute are ranked last. It returns the kept items followed by the dropped ones.
+func (c MBeanConfig) limitItems(items []*WeblogicAPIResponse) (kept, dropped []*WeblogicAPIResponse) {
+	if c.MaxItems == 0 || len(items) <= c.MaxItems {
+		return items, nil
+	}
+	type rankedItem struct {
+		item  *WeblogicAPIResponse
+		value float64
+		ok    bool
+	}
+	ranked := make([]rankedItem, len(items))
+	for i, item := range items {
+		value, ok := c.numericalFields(item)[c.SortBy]
+		ranked[i] = rankedItem{item: item, value: value, ok: ok}
+	}
+	if c.SortBy != "" {
+		sort.SliceStable(ranked, func(i, j int) bool {
+			if ranked[i].ok != ranked[j].ok {
+				return ranked[i].ok
+			}
+			return ranked[i].value > ranked[j].value
+		})
+	}
+	sorted := make([]*WeblogicAPIResponse, len(ranked))
+	for i, r := range ranked {
+		sorted[i] = r.item
+	}
+	return sorted[:c.MaxItems], sorted[c.MaxItems:]
+}
+
+// metricName returns the name of the metric produced by an aggregation over the collection beanName.
+func (a Aggregation) metricName(beanName string, c MBeanConfig) string {
+	if a.Name != "" {
+		return c.prefixedName(a.Name)
+	}
+	if a.Field == "" {
+		return c.prefixedName(c.Naming.convertCase(beanName) + "_" + a.Op)
+	}
+	return c.prefixedName(c.Naming.convertCase(a.Field) + "_" + a.Op)
+}
+
+// apply rolls the aggregation's field up across a collection's items, using the collection's config to read their
+// attributes. It returns false if there's nothing to aggregate, such as the minimum of an empty collection.
+func (a Aggregation) apply(c MBeanConfig, items []*WeblogicAPIResponse) (float64, bool) {
+	var values []float64
+	for _, item := range items {
+		if a.Field == "" {
+			values = append(values, 0)
+		} else if value, ok := c.numericalFields(item)[a.Field]; ok {
+			values = append(values, value)
+		}
+	}
+	if a.Op == "count" {
+		return float64(len(values)), true
+	}
+	if a.Op == "sum" {
+		sum := 0.0
+		for _, v := range values {
+			sum += v
+		}
+		return sum, true
+	}
+	if len(values) == 0 {
+		return 0, false
+	}
+	result := values[0]
+	for _, v := range values[1:] {
+		switch a.Op {
+		case "min":
+			result = math.Min(result, v)
+		case "max":
+			result = math.Max(result, v)
+		case "avg":
+			result += v
+		}
+	}
+	if a.Op == "avg" {
+		result /= float64(len(values))
+	}
+	return result, true
+}
+
+// otherBucket sums the numerical fields of dropped collection items into a single item labelled "other".
+func (c MBeanConfig) otherBucket(dropped []*WeblogicAPIResponse) *WeblogicAPIResponse {
+	bucket := &WeblogicAPIResponse{
+		NumericalFields: make(map[string]float64),
+		StringFields:    map[string]string{c.LabelValueAttribute: otherLabelValue},
 	}
+	for _, item := range dropped {
+		for fieldName, fieldValue := range c.numericalFields(item) {
+			bucket.NumericalFields[fieldName] += fieldValue
+		}
+	}
+	return bucket
 }
 
-// New creates an exporter from an MBeanQuery
-func New(q MbeanQuery) (Exporter, error) {
-	if len(q.Children) == 0 && len(q.Fields) == 0 {
+// New creates an exporter from an MBeanQuery and exporter wide options
+func New(q MbeanQuery, opts Options) (Exporter, error) {
+	if len(q.Children) == 0 && len(q.Fields) == 0 && len(q.StringFields) == 0 {
 		return Exporter{}, errors.New("Cannot use empty config. No queries specified")
 	}
+	if err := opts.validate(); err != nil {
+		return Exporter{}, err
+	}
 	configMap := MBeanConfigMap{}
-	configMap.createConfigMap("serverRuntime", &q)
+	if err := configMap.createConfigMap("serverRuntime", &q, opts, nil); err != nil {
+		return Exporter{}, err
+	}
+	if err := configMap.validateBeanMetricNames(); err != nil {
+		return Exporter{}, err
+	}
 
 	query := q.getRESTQuery()
 
@@ -119,6 +602,11 @@ func New(q MbeanQuery) (Exporter, error) {
 		configMap:   configMap,
 		client:      http.Client{Timeout: 10 * time.Second},
 		query:       query,
+		groups:      q.refreshGroups("serverRuntime", nil, nil),
+		responses:   newResponseCache(),
+
+		missingFieldLog:    newThrottledLog(missingFieldLogInterval),
+		duplicateSeriesLog: newThrottledLog(missingFieldLogInterval),
 	}, nil
 }
 
@@ -138,7 +626,8 @@ func (q *MbeanQuery) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
-// GetRESTQueryJSON provides the raw json required to send a request to the WLS API
+// GetRESTQueryJSON provides the raw json of a query to the WLS API for the whole mBean tree. Parts of the tree with
+// refresh intervals are actually sent as separate queries.
 func (e *Exporter) GetRESTQueryJSON() (json.RawMessage, error) {
 	qJSON, err := json.Marshal(&e.query)
 	if err != nil {
@@ -150,12 +639,29 @@ func (e *Exporter) GetRESTQueryJSON() (json.RawMessage, error) {
 
 // GetRESTQuery produces the JSON query for each mbean object to be used in the WLS api.
 func (q *MbeanQuery) getRESTQuery() wls.WLSRestQuery {
+	return q.restQuery(false)
+}
+
+// restQuery produces the query of the mBean. If splitRefreshed is true, children with refresh intervals are left out
+// as they're fetched separately.
+func (q *MbeanQuery) restQuery(splitRefreshed bool) wls.WLSRestQuery {
 	children := make(map[string]*wls.WLSRestQuery)
 	for name, config := range q.Children {
-		q := config.getRESTQuery()
+		if splitRefreshed && config.RefreshInterval > 0 {
+			continue
+		}
+		q := config.restQuery(splitRefreshed)
 		children[name] = &q
 	}
 
+	// Leave fields unset when using the wildcard, which makes the WLS api return all fields.
+	if q.allFields() {
+		return wls.WLSRestQuery{
+			Children: children,
+			Links:    []string{},
+		}
+	}
+
 	// Set empty array if fields isn't set, otherwise WLS api returns all fields.
 	var fields []string
 	if len(q.Fields)+len(q.StringFields) != 0 {
@@ -172,6 +678,27 @@ func (q *MbeanQuery) getRESTQuery() wls.WLSRestQuery {
 		fields = append(fields, q.LabelValueAttribute)
 	}
 
+	// Attributes used only to compute other metrics, such as derived and info metrics, must also be returned
+	for _, field := range q.internalFields() {
+		if !stringInSlice(field, fields) {
+			fields = append(fields, field)
+		}
+	}
+
+	// Attributes used to filter collection items must also be returned
+	for _, filter := range []map[string]string{q.Include, q.Exclude} {
+		attributes := make([]string, 0, len(filter))
+		for attribute := range filter {
+			attributes = append(attributes, attribute)
+		}
+		sort.Strings(attributes)
+		for _, attribute := range attributes {
+			if !stringInSlice(attribute, fields) {
+				fields = append(fields, attribute)
+			}
+		}
+	}
+
 	return wls.WLSRestQuery{
 		Fields:   fields,
 		Children: children,
@@ -179,9 +706,45 @@ func (q *MbeanQuery) getRESTQuery() wls.WLSRestQuery {
 	}
 }
 
-// DoQuery performs a Weblogic query and returns the Prometheus metrics generated from the Weblogic API response
-func (e *Exporter) DoQuery(host string, port int, username, password string) ([]prometheus.Gauge, error) {
-	queryJSON, err := e.GetRESTQueryJSON()
+// DoQuery performs a Weblogic query and returns the Prometheus metrics generated from the Weblogic API response,
+// along with stats describing the probe. Stats are returned even if the probe fails, covering the phases that completed.
+// Parts of the mBean tree with refresh intervals are fetched separately, reusing cached responses when they're fresh.
+func (e *Exporter) DoQuery(host string, port int, username, password string) ([]prometheus.Gauge, ProbeStats, error) {
+	stats := ProbeStats{}
+	target := fmt.Sprintf("%s:%d", host, port)
+
+	w := WeblogicAPIResponse{}
+	for _, group := range e.groups {
+		groupResp, ok := e.responses.load(target, username, password, group)
+		if !ok {
+			var err error
+			groupResp, err = e.fetch(host, port, username, password, group.query, &stats)
+			if err != nil {
+				return nil, stats, err
+			}
+			if group.interval > 0 {
+				e.responses.store(target, username, password, group, groupResp)
+			}
+		}
+		start := time.Now()
+		e.configMap.mergeResponse("serverRuntime", &w, groupResp)
+		stats.ParseDuration += time.Since(start)
+	}
+	start := time.Now()
+	metrics, err := e.CreateMetrics(&w)
+	stats.MetricsDuration = time.Since(start)
+	if err != nil {
+		return nil, stats, err
+	}
+	metrics = append(metrics, e.missingFieldMetrics(&w)...)
+	stats.Series = len(metrics)
+
+	return metrics, stats, err
+}
+
+// fetch sends a query to the WLS API and parses its response, adding the time taken and size of the response to stats.
+func (e *Exporter) fetch(host string, port int, username, password string, query wls.WLSRestQuery, stats *ProbeStats) (*WeblogicAPIResponse, error) {
+	queryJSON, err := json.Marshal(&query)
 	if err != nil {
 		return nil, err
 	}
@@ -199,29 +762,33 @@ func (e *Exporter) DoQuery(host string, port int, username, password string) ([]
 	req.Header.Add("Content-Type", "application/json")
 	req.SetBasicAuth(username, password)
 
+	start := time.Now()
 	resp, err := e.client.Do(req)
 	if err != nil {
+		stats.RequestDuration += time.Since(start)
 		return nil, err
 	}
 	defer resp.Body.Close()
+	stats.StatusCode = resp.StatusCode
 
 	body, err := ioutil.ReadAll(resp.Body)
+	stats.RequestDuration += time.Since(start)
+	stats.ResponseSize += len(body)
 	if err != nil {
 		return nil, err
 	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Weblogic API returned unexpected status %s", resp.Status)
+	}
 
+	start = time.Now()
 	w := WeblogicAPIResponse{}
 	err = json.Unmarshal(body, &w)
+	stats.ParseDuration += time.Since(start)
 	if err != nil {
 		return nil, err
 	}
-
-	metrics, err := e.CreateMetrics(&w)
-	if err != nil {
-		return nil, err
-	}
-
-	return metrics, err
+	return &w, nil
 }
 
 /*
@@ -229,12 +796,10 @@ UnmarshalJSON implements the Unmarshaler interface for WeblogicAPIResponse
 */
 func (w *WeblogicAPIResponse) UnmarshalJSON(data []byte) error {
 	jsonData := map[string]interface{}{}
-	json.Unmarshal(data, &jsonData)
-	err := w.parseAPIResponse(jsonData)
-	if err != nil {
-		return err
+	if err := json.Unmarshal(data, &jsonData); err != nil {
+		return fmt.Errorf("Invalid Weblogic API response: %s", err.Error())
 	}
-	return nil
+	return w.parseAPIResponse("$", jsonData)
 }
 
 /*
@@ -247,42 +812,56 @@ var weblogicObjectFieldNames map[string]bool = map[string]bool{
 
 /*
 parseAPIResponse unpicks the Weblogic API's JSON response into a proper struct representation
-an handles some idiosyncracies of the API.
+an handles some idiosyncracies of the API. The path is the JSON path of the data, used to describe errors.
 */
-func (w *WeblogicAPIResponse) parseAPIResponse(data map[string]interface{}) error {
+func (w *WeblogicAPIResponse) parseAPIResponse(path string, data map[string]interface{}) error {
 	for key, value := range data {
+		keyPath := path + "." + key
 		switch value := value.(type) {
 		case []interface{}:
-			if w.Items == nil {
-				w.Items = make([]*WeblogicAPIResponse, 0, len(value))
-			}
-			// Weblogic API will return a list with a single empty object rather than an empty list, so need to check
-			// first item to see if a list is empty
-			if len(value) == 1 && len(value[0].(map[string]interface{})) == 0 {
+			// Weblogic wraps collections as {"items": [...]}, so any other array, such as deploymentTargets, is an
+			// attribute rather than a collection. Only its length can be exported.
+			if key != "items" {
+				if w.ArrayLengths == nil {
+					w.ArrayLengths = make(map[string]int)
+				}
+				w.ArrayLengths[key] = len(value)
 				break
 			}
-			for _, item := range value {
-				i, ok := item.(map[string]interface{})
-				if !ok {
-					return fmt.Errorf("Invalid item type at %v, expected object but got type %T", key, value)
-				}
-				childItem := WeblogicAPIResponse{}
-				err := childItem.parseAPIResponse(i)
-				if err != nil {
-					return err
-				}
-				w.Items = append(w.Items, &childItem)
+			items, err := parseItems(keyPath, value)
+			if err != nil {
+				return err
+			}
+			if w.Items == nil {
+				w.Items = make([]*WeblogicAPIResponse, 0, len(items))
 			}
+			w.Items = append(w.Items, items...)
 		case float64:
 			if w.NumericalFields == nil {
 				w.NumericalFields = make(map[string]float64)
 			}
 			w.NumericalFields[key] = value
+		case bool:
+			// Booleans are exported as 1 for true and 0 for false
+			if w.NumericalFields == nil {
+				w.NumericalFields = make(map[string]float64)
+			}
+			if value {
+				w.NumericalFields[key] = 1
+			} else {
+				w.NumericalFields[key] = 0
+			}
 		case string:
 			if w.StringFields == nil {
 				w.StringFields = make(map[string]string)
 			}
 			w.StringFields[key] = value
+		case nil:
+			// Null attributes can't be exported, but are recorded so they're known to have been returned
+			if w.NullFields == nil {
+				w.NullFields = make(map[string]bool)
+			}
+			w.NullFields[key] = true
 		case map[string]interface{}:
 			// Check if item is a field or a child mBean
 			if _, ok := weblogicObjectFieldNames[key]; ok {
@@ -295,17 +874,51 @@ func (w *WeblogicAPIResponse) parseAPIResponse(data map[string]interface{}) erro
 					w.Children = make(map[string]*WeblogicAPIResponse)
 				}
 				childBean := WeblogicAPIResponse{}
-				err := childBean.parseAPIResponse(value)
+				err := childBean.parseAPIResponse(keyPath, value)
 				if err != nil {
 					return err
 				}
 				w.Children[key] = &childBean
 			}
+		default:
+			return fmt.Errorf("Unexpected value of type %T at %s", value, keyPath)
 		}
 	}
 	return nil
 }
 
+// parseItems parses the items array of a collection mBean from the Weblogic API. Every item must be an object.
+func parseItems(path string, value []interface{}) ([]*WeblogicAPIResponse, error) {
+	items := make([]*WeblogicAPIResponse, 0, len(value))
+	// Weblogic API will return a list with a single empty object rather than an empty list, so need to check
+	// first item to see if a list is empty
+	if obj, ok := firstObject(value); ok && len(value) == 1 && len(obj) == 0 {
+		return items, nil
+	}
+	for i, item := range value {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+		obj, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid item type at %s, expected object but got %T", itemPath, item)
+		}
+		childItem := WeblogicAPIResponse{}
+		if err := childItem.parseAPIResponse(itemPath, obj); err != nil {
+			return nil, err
+		}
+		items = append(items, &childItem)
+	}
+	return items, nil
+}
+
+// firstObject returns the first element of an array if it's an object.
+func firstObject(value []interface{}) (map[string]interface{}, bool) {
+	if len(value) == 0 {
+		return nil, false
+	}
+	obj, ok := value[0].(map[string]interface{})
+	return obj, ok
+}
+
 func stringInSlice(a string, list []string) bool {
 	for _, b := range list {
 		if b == a {
@@ -320,39 +933,114 @@ CreateMetrics uses an exporter to create metrics from a Weblogic API reseponse
 */
 func (e *Exporter) CreateMetrics(resp *WeblogicAPIResponse) (metrics []prometheus.Gauge, err error) {
 	// Start at serverRuntime, which is the root node of Weblogic's runtime mBean tree.
-	serverMetrics, err := e.createMBeanMetrics("serverRuntime", resp, nil)
+	sizes := make(collectionSizes)
+	serverMetrics, err := e.createMBeanMetrics("serverRuntime", resp, nil, sizes)
 	if err != nil {
 		return nil, err
 	}
-	return serverMetrics, nil
+	return append(serverMetrics, sizes.gauges()...), nil
+}
+
+// DuplicateSeriesDropped counts the series dropped for duplicating another item's series, in collections whose items
+// can't be told apart. It should be registered with the exporter's own registry.
+var DuplicateSeriesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "weblogic_exporter_duplicate_series_dropped_total",
+	Help: "Number of series dropped for duplicating another item of the same collection mBean",
+}, []string{"mbean"})
+
+/*
+dropDuplicateSeries removes the metrics of a collection item that have the same name and labels as those of an item
+before it, which can't be registered together. seen holds the series of the earlier items. This only happens when the
+collection has no label_name, or a descendant replaces its label under label_conflict: child_wins, so other
+duplicates are left to fail registration as a config error.
+*/
+func (e *Exporter) dropDuplicateSeries(beanName string, metricConfig MBeanConfig, metrics []prometheus.Gauge, seen map[string]bool) []prometheus.Gauge {
+	kept := metrics[:0]
+	for _, m := range metrics {
+		// A metric's description holds its name and sorted labels, so it identifies the series
+		desc := m.Desc().String()
+		if !seen[desc] {
+			seen[desc] = true
+			kept = append(kept, m)
+			continue
+		}
+		DuplicateSeriesDropped.WithLabelValues(beanName).Inc()
+		if e.duplicateSeriesLog == nil {
+			continue
+		}
+		if metricConfig.LabelName == "" {
+			e.duplicateSeriesLog.Printf(desc, "Dropped series %s duplicated by another item of mBean %s. Set a label_name to tell its items apart",
+				desc, beanName)
+		} else {
+			e.duplicateSeriesLog.Printf(desc, "Dropped series %s duplicated by another item of mBean %s, "+
+				"whose label is replaced by a descendant under label_conflict: child_wins", desc, beanName)
+		}
+	}
+	return kept
 }
 
 /*
 CreateMBeanMetrics creates a series of Prometheus metrics from an mBean name, a set of labels, and an API response that contains
-the metrics for that mBean. It also recursively creates child metrics.
+the metrics for that mBean. It also recursively creates child metrics, recording the size of each collection in sizes.
 */
-func (e *Exporter) createMBeanMetrics(beanName string, resp *WeblogicAPIResponse, labels prometheus.Labels) (metrics []prometheus.Gauge, err error) {
+func (e *Exporter) createMBeanMetrics(beanName string, resp *WeblogicAPIResponse, labels prometheus.Labels, sizes collectionSizes) (metrics []prometheus.Gauge, err error) {
 	metricConfig, ok := e.configMap[beanName]
 	if !ok {
 		return nil, fmt.Errorf("Unable to find monitoring config for mBean %s", beanName)
 	}
+	// Add extra labels from parameter. The mBean's own label is set afterwards so it wins if it shares a parent's name.
 	beanLabels := make(prometheus.Labels)
+	copyLabels(beanLabels, labels)
+
 	mainLabelValue, ok := resp.StringFields[metricConfig.LabelValueAttribute]
 	if ok {
 		beanLabels[metricConfig.LabelName] = mainLabelValue
 	}
 
-	// Add extra labels from parameter
-	copyLabels(beanLabels, labels)
+	numericalFields := metricConfig.numericalFields(resp)
+	metrics = make([]prometheus.Gauge, 0, len(numericalFields))
 
-	metrics = make([]prometheus.Gauge, 0, len(resp.NumericalFields))
+	for fieldName, fieldValue := range numericalFields {
+		if metricConfig.ExcludeFields[fieldName] || metricConfig.HiddenFields[fieldName] {
+			continue
+		}
+		for _, m := range metricConfig.convertField(fieldName, fieldValue) {
+			var gauge prometheus.Gauge = prometheus.NewGauge(prometheus.GaugeOpts{
+				Name:        m.name,
+				ConstLabels: beanLabels,
+			})
+			gauge.Set(m.value)
+			if m.counter {
+				gauge = counterGauge{gauge}
+			}
+			metrics = append(metrics, gauge)
+		}
+	}
 
-	for fieldName, fieldValue := range resp.NumericalFields {
+	// Export the lengths of array attributes typed as length
+	for fieldName, length := range resp.ArrayLengths {
+		if metricConfig.FieldTypes[fieldName] != fieldTypeLength || metricConfig.ExcludeFields[fieldName] {
+			continue
+		}
 		gauge := prometheus.NewGauge(prometheus.GaugeOpts{
-			Name:        metricConfig.MetricPrefix + strcase.ToSnake(fieldName),
+			Name:        metricConfig.metricName(fieldName, "length"),
 			ConstLabels: beanLabels,
 		})
-		gauge.Set(fieldValue)
+		gauge.Set(float64(length))
+		metrics = append(metrics, gauge)
+	}
+
+	// Create derived metrics, skipping any whose fields are missing or that divide by zero
+	for _, d := range metricConfig.Derived {
+		value, ok := d.expr.eval(numericalFields)
+		if !ok {
+			continue
+		}
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        metricConfig.prefixedName(metricConfig.Naming.convertCase(d.name)),
+			ConstLabels: beanLabels,
+		})
+		gauge.Set(value)
 		metrics = append(metrics, gauge)
 	}
 
@@ -366,7 +1054,7 @@ func (e *Exporter) createMBeanMetrics(beanName string, resp *WeblogicAPIResponse
 			for potentialValue := range potentialValues {
 				fieldLabels[labelName] = potentialValue
 				gauge := prometheus.NewGauge(prometheus.GaugeOpts{
-					Name:        metricConfig.MetricPrefix + strcase.ToSnake(fieldName),
+					Name:        metricConfig.prefixedName(metricConfig.Naming.convertCase(fieldName)),
 					ConstLabels: fieldLabels,
 				})
 				if potentialValue == responseValue {
@@ -376,14 +1064,44 @@ func (e *Exporter) createMBeanMetrics(beanName string, resp *WeblogicAPIResponse
 				}
 				metrics = append(metrics, gauge)
 			}
+
+			// Handle values missing from the value set according to the field's policy
+			unknown := !potentialValues[responseValue]
+			switch metricConfig.UnknownValues[fieldName] {
+			case unknownValuesOther:
+				fieldLabels[labelName] = otherLabelValue
+				gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+					Name:        metricConfig.prefixedName(metricConfig.Naming.convertCase(fieldName)),
+					ConstLabels: fieldLabels,
+				})
+				if unknown {
+					gauge.Set(1)
+				}
+				metrics = append(metrics, gauge)
+			case unknownValuesLabel:
+				if unknown {
+					fieldLabels[labelName] = responseValue
+					gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+						Name:        metricConfig.prefixedName(metricConfig.Naming.convertCase(fieldName)),
+						ConstLabels: fieldLabels,
+					})
+					gauge.Set(1)
+					metrics = append(metrics, gauge)
+				}
+			case unknownValuesCount:
+				if unknown {
+					countUnknownStringValue(beanName, fieldName, responseValue)
+				}
+			}
 		}
 	}
 
 	// Handle healthstate metrics, which have standard string outputs that can be
 	// easily converted to labels
 	if hs, ok := resp.ObjectFields["healthState"]; ok {
-		hsw := hs.(map[string]interface{})
-		hsString := hsw["state"].(string)
+		// A missing or malformed state leaves every state at 0
+		hsw, _ := hs.(map[string]interface{})
+		hsString, _ := hsw["state"].(string)
 
 		states := []string{"ok", "overloaded", "warn", "critical", "failed"}
 		for _, s := range states {
@@ -391,7 +1109,7 @@ func (e *Exporter) createMBeanMetrics(beanName string, resp *WeblogicAPIResponse
 			copyLabels(stateLabels, beanLabels)
 			stateLabels["state"] = s
 			gauge := prometheus.NewGauge(prometheus.GaugeOpts{
-				Name:        metricConfig.MetricPrefix + "health_state",
+				Name:        metricConfig.prefixedName(metricConfig.Naming.convertCase("healthState")),
 				ConstLabels: stateLabels,
 			})
 			if s == hsString {
@@ -403,17 +1121,77 @@ func (e *Exporter) createMBeanMetrics(beanName string, resp *WeblogicAPIResponse
 		}
 	}
 
+	// Create the info metric. Collections are skipped as their items carry the attributes.
+	if len(metricConfig.Info.Fields) != 0 && resp.Items == nil {
+		infoLabels := make(prometheus.Labels)
+		copyLabels(infoLabels, beanLabels)
+		for _, field := range metricConfig.Info.Fields {
+			// Attributes missing from the response are left empty so the metric always has the same labels
+			value := resp.StringFields[field]
+			if numValue, ok := numericalFields[field]; ok {
+				value = strconv.FormatFloat(numValue, 'f', -1, 64)
+			}
+			infoLabels[strcase.ToSnake(field)] = value
+		}
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        metricConfig.infoName(beanName),
+			ConstLabels: infoLabels,
+		})
+		gauge.Set(1)
+		metrics = append(metrics, gauge)
+	}
+
 	// Recursively create child metrics
+	items := make([]*WeblogicAPIResponse, 0, len(resp.Items))
 	for _, item := range resp.Items {
-		itemMetrics, err := e.createMBeanMetrics(beanName, item, beanLabels)
+		if metricConfig.includesItem(item) {
+			items = append(items, item)
+		}
+	}
+	if resp.Items != nil {
+		// Each collection gets its own metric name, as collections at different depths of the tree have different labels
+		sizes.add(metricConfig.Naming.beanMetricName(beanName, "collection_size"), beanLabels, len(items))
+		for _, a := range metricConfig.Aggregations {
+			value, ok := a.apply(metricConfig, items)
+			if !ok {
+				continue
+			}
+			gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+				Name:        a.metricName(beanName, metricConfig),
+				ConstLabels: beanLabels,
+			})
+			gauge.Set(value)
+			metrics = append(metrics, gauge)
+		}
+	}
+	if metricConfig.AggregateOnly {
+		items = nil
+	} else if resp.Items != nil && metricConfig.MaxItems > 0 {
+		var dropped []*WeblogicAPIResponse
+		items, dropped = metricConfig.limitItems(items)
+		ItemsDropped.WithLabelValues(beanName).Add(float64(len(dropped)))
+		if metricConfig.OtherBucket && len(dropped) > 0 {
+			items = append(items, metricConfig.otherBucket(dropped))
+		}
+	}
+	seen := make(map[string]bool)
+	for _, item := range items {
+		itemMetrics, err := e.createMBeanMetrics(beanName, item, beanLabels, sizes)
 		if err != nil {
 			return nil, err
 		}
+		if metricConfig.LabelName == "" || metricConfig.LabelReplaced {
+			itemMetrics = e.dropDuplicateSeries(beanName, metricConfig, itemMetrics, seen)
+		}
 		metrics = append(metrics, itemMetrics...)
 	}
 
 	for childName, child := range resp.Children {
-		childMetrics, err := e.createMBeanMetrics(childName, child, beanLabels)
+		// Requesting every attribute returns object attributes that look like child mBeans, so unconfigured ones are skipped
+		if _, ok := e.configMap[childName]; !ok && metricConfig.AllFields {
+			continue
+		}
+		childMetrics, err := e.createMBeanMetrics(childName, child, beanLabels, sizes)
 		if err != nil {
 			return nil, err
 		}
@@ -422,6 +1200,51 @@ func (e *Exporter) createMBeanMetrics(beanName string, resp *WeblogicAPIResponse
 	return metrics, nil
 }
 
+/*
+collectionSizes accumulates the number of items in each collection mBean while creating metrics, keyed by metric name
+and labels. Collections whose parent items aren't labelled share the same labels, so their counts are summed.
+*/
+type collectionSizes map[string]*collectionSize
+
+type collectionSize struct {
+	name   string
+	labels prometheus.Labels
+	count  int
+}
+
+// add records the number of items in a collection, labelled by its parent's labels.
+func (cs collectionSizes) add(name string, labels prometheus.Labels, count int) {
+	// Printing a map sorts its keys, so this uniquely identifies the series
+	key := name + fmt.Sprint(labels)
+	if size, ok := cs[key]; ok {
+		size.count += count
+		return
+	}
+	sizeLabels := make(prometheus.Labels)
+	copyLabels(sizeLabels, labels)
+	cs[key] = &collectionSize{name: name, labels: sizeLabels, count: count}
+}
+
+// gauges converts the collection sizes into metrics.
+func (cs collectionSizes) gauges() []prometheus.Gauge {
+	keys := make([]string, 0, len(cs))
+	for key := range cs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	gauges := make([]prometheus.Gauge, 0, len(cs))
+	for _, key := range keys {
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        cs[key].name,
+			Help:        "Number of items in the collection mBean, after filtering",
+			ConstLabels: cs[key].labels,
+		})
+		gauge.Set(float64(cs[key].count))
+		gauges = append(gauges, gauge)
+	}
+	return gauges
+}
+
 func copyLabels(new, old prometheus.Labels) {
 	for k, v := range old {
 		new[k] = v
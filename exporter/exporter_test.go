@@ -1,9 +1,20 @@
 package exporter
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -310,6 +321,25 @@ var responseTestCases = []struct {
 			},
 		},
 	},
+	{
+		queries:     MbeanQuery{},
+		apiResponse: `{"name":"admin-server","clusterName":null,"applicationRuntimes":{"items":[{"name":"console","deploymentTargets":["admin-server","cluster-1"],"sourceInfo":[]}]}}`,
+		expectError: false,
+		parsedResponse: WeblogicAPIResponse{
+			StringFields: map[string]string{"name": "admin-server"},
+			NullFields:   map[string]bool{"clusterName": true},
+			Children: map[string]*WeblogicAPIResponse{
+				"applicationRuntimes": {
+					Items: []*WeblogicAPIResponse{
+						{
+							StringFields: map[string]string{"name": "console"},
+							ArrayLengths: map[string]int{"deploymentTargets": 2, "sourceInfo": 0},
+						},
+					},
+				},
+			},
+		},
+	},
 }
 
 type metricTestSpec struct {
@@ -376,6 +406,20 @@ func TestUnmarshalResponse(t *testing.T) {
 	}
 }
 
+func TestParseResponseErrors(t *testing.T) {
+	for apiResponse, wantErr := range map[string]string{
+		`{"name":`:         "unexpected end of JSON input",
+		`["admin-server"]`: "Invalid Weblogic API response: json: cannot unmarshal array into Go value of type map[string]interface {}",
+		`{"applicationRuntimes":{"items":[{"name":"console","componentRuntimes":{"items":[{},"broken"]}}]}}`: "Invalid item type at $.applicationRuntimes.items[0].componentRuntimes.items[1], expected object but got string",
+	} {
+		resp := WeblogicAPIResponse{}
+		err := json.Unmarshal([]byte(apiResponse), &resp)
+		if err == nil || err.Error() != wantErr {
+			t.Errorf("Want error %q\nGot %v\n", wantErr, err)
+		}
+	}
+}
+
 func BenchmarkParseResponse(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		for _, testCase := range responseTestCases {
@@ -397,7 +441,7 @@ func TestCreateMetrics(t *testing.T) {
 			gauges[i] = g
 		}
 
-		e, err := New(tc.queries)
+		e, err := New(tc.queries, Options{})
 		if err != nil {
 			t.Fatalf(err.Error())
 		}
@@ -410,6 +454,1391 @@ func TestCreateMetrics(t *testing.T) {
 	}
 }
 
+var filterTestCases = []struct {
+	queries MbeanQuery
+	metrics []metricTestSpec
+}{
+	{
+		queries: MbeanQuery{
+			LabelName:           "server",
+			LabelValueAttribute: "name",
+			Children: map[string]MbeanQuery{
+				"applicationRuntimes": {
+					Children: map[string]MbeanQuery{
+						"componentRuntimes": {
+							LabelName:           "component_runtime",
+							LabelValueAttribute: "name",
+							Fields:              []string{"sessionsOpenedTotalCount"},
+							Exclude:             map[string]string{"name": "admin-server_/(bea_wls_internal|console.*|weblogic)"},
+							Children: map[string]MbeanQuery{
+								"servlets": {
+									LabelName:           "servlet",
+									LabelValueAttribute: "servletName",
+									Fields:              []string{"invocationTotalCount"},
+									Include:             map[string]string{"servletName": "Jsp.*"},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		metrics: []metricTestSpec{
+			{
+				name:   "deployment_state",
+				labels: map[string]string{"server": "admin-server", "component_runtime": "admin-server_/management"},
+				value:  2,
+			},
+			{
+				name:   "sessions_opened_total_count",
+				labels: map[string]string{"server": "admin-server", "component_runtime": "admin-server_/management"},
+				value:  19,
+			},
+			{
+				name:   "execution_time_average",
+				labels: map[string]string{"server": "admin-server", "component_runtime": "admin-server_/management", "servlet": "JspServlet"},
+				value:  180,
+			},
+			{
+				name:   "execution_time_high",
+				labels: map[string]string{"server": "admin-server", "component_runtime": "admin-server_/management", "servlet": "JspServlet"},
+				value:  0,
+			},
+			{
+				name:   "execution_time_total",
+				labels: map[string]string{"server": "admin-server", "component_runtime": "admin-server_/management", "servlet": "JspServlet"},
+				value:  2465,
+			},
+			{
+				name:   "invocation_total_count",
+				labels: map[string]string{"server": "admin-server", "component_runtime": "admin-server_/management", "servlet": "JspServlet"},
+				value:  272,
+			},
+			{
+				name:   "deployment_state",
+				labels: map[string]string{"server": "admin-server", "component_runtime": "jms-internal-notran-adp"},
+				value:  2,
+			},
+			{
+				name:   "deployment_state",
+				labels: map[string]string{"server": "admin-server", "component_runtime": "mejb"},
+				value:  2,
+			},
+			{
+				name:   "deployment_state",
+				labels: map[string]string{"server": "admin-server", "component_runtime": "jms-internal-xa-adp"},
+				value:  2,
+			},
+			{
+				name:   "weblogic_application_runtimes_collection_size",
+				labels: map[string]string{"server": "admin-server"},
+				value:  7,
+			},
+			{
+				name:   "weblogic_component_runtimes_collection_size",
+				labels: map[string]string{"server": "admin-server"},
+				value:  4,
+			},
+			{
+				name:   "weblogic_servlets_collection_size",
+				labels: map[string]string{"server": "admin-server", "component_runtime": "admin-server_/management"},
+				value:  1,
+			},
+		},
+	},
+}
+
+func TestItemFilters(t *testing.T) {
+	for _, tc := range filterTestCases {
+		e, err := New(tc.queries, Options{})
+		if err != nil {
+			t.Fatalf(err.Error())
+		}
+		// Strip the unrelated mBeans from the response so only the filtered collections remain
+		resp := responseTestCases[1].parsedResponse
+		resp.Children = map[string]*WeblogicAPIResponse{"applicationRuntimes": resp.Children["applicationRuntimes"]}
+
+		genMetrics, err := e.CreateMetrics(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := sortMetricSpecs(tc.metrics)
+		got := gatherMetricSpecs(t, genMetrics)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("Want %v\nGot %v\n", want, got)
+		}
+	}
+}
+
+func TestInvalidFilterRegex(t *testing.T) {
+	q := MbeanQuery{
+		Children: map[string]MbeanQuery{
+			"applicationRuntimes": {Include: map[string]string{"name": "("}},
+		},
+	}
+	if _, err := New(q, Options{}); err == nil {
+		t.Error("Expected an error for an invalid include regex")
+	}
+}
+
+func TestItemLimits(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		Children: map[string]MbeanQuery{
+			"servlets": {
+				LabelName:           "servlet",
+				LabelValueAttribute: "servletName",
+				Fields:              []string{"invocationTotalCount"},
+				MaxItems:            1,
+				SortBy:              "invocationTotalCount",
+				OtherBucket:         true,
+			},
+		},
+	}
+	resp := WeblogicAPIResponse{
+		StringFields: map[string]string{"name": "admin-server"},
+		Children: map[string]*WeblogicAPIResponse{
+			"servlets": {
+				Items: []*WeblogicAPIResponse{
+					{StringFields: map[string]string{"servletName": "FileServlet"}, NumericalFields: map[string]float64{"invocationTotalCount": 3}},
+					{StringFields: map[string]string{"servletName": "JspServlet"}, NumericalFields: map[string]float64{"invocationTotalCount": 272}},
+					{StringFields: map[string]string{"servletName": "ready"}, NumericalFields: map[string]float64{"invocationTotalCount": 1}},
+				},
+			},
+		},
+	}
+	want := sortMetricSpecs([]metricTestSpec{
+		{name: "invocation_total_count", labels: map[string]string{"server": "admin-server", "servlet": "JspServlet"}, value: 272},
+		{name: "invocation_total_count", labels: map[string]string{"server": "admin-server", "servlet": "other"}, value: 4},
+		{name: "weblogic_servlets_collection_size", labels: map[string]string{"server": "admin-server"}, value: 3},
+	})
+
+	ItemsDropped.Reset()
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+	if dropped := gatherCounter(t, ItemsDropped); !reflect.DeepEqual(dropped, map[string]float64{"servlets": 2}) {
+		t.Errorf("Want 2 servlets dropped, got %v", dropped)
+	}
+
+	// Sorting and bucketing only apply to dropped items
+	q.Children["servlets"] = MbeanQuery{SortBy: "invocationTotalCount", OtherBucket: true, LabelName: "servlet"}
+	if _, err := New(q, Options{}); err == nil {
+		t.Error("Expected an error for sort_by and other_bucket without max_items")
+	}
+}
+
+// gatherCounter returns the values of a counter labelled by mBean, such as ItemsDropped, keyed by mBean.
+func gatherCounter(t *testing.T, counter *prometheus.CounterVec) map[string]float64 {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(counter)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dropped := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			dropped[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+		}
+	}
+	return dropped
+}
+
+// Limits at two levels of the tree must each count their dropped items, despite their parents having different labels.
+func TestNestedItemLimits(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		Children: map[string]MbeanQuery{
+			"applicationRuntimes": {
+				LabelName:           "app",
+				LabelValueAttribute: "name",
+				MaxItems:            1,
+				Children: map[string]MbeanQuery{
+					"componentRuntimes": {
+						LabelName:           "component",
+						LabelValueAttribute: "name",
+						Fields:              []string{"openSessionsCurrentCount"},
+						MaxItems:            1,
+					},
+				},
+			},
+		},
+	}
+	component := func(name string) *WeblogicAPIResponse {
+		return &WeblogicAPIResponse{
+			StringFields:    map[string]string{"name": name},
+			NumericalFields: map[string]float64{"openSessionsCurrentCount": 1},
+		}
+	}
+	resp := WeblogicAPIResponse{
+		StringFields: map[string]string{"name": "admin-server"},
+		Children: map[string]*WeblogicAPIResponse{
+			"applicationRuntimes": {
+				Items: []*WeblogicAPIResponse{
+					{
+						StringFields: map[string]string{"name": "app1"},
+						Children: map[string]*WeblogicAPIResponse{
+							"componentRuntimes": {Items: []*WeblogicAPIResponse{component("c1"), component("c2"), component("c3")}},
+						},
+					},
+					{StringFields: map[string]string{"name": "app2"}},
+				},
+			},
+		},
+	}
+	want := sortMetricSpecs([]metricTestSpec{
+		{name: "open_sessions_current_count", labels: map[string]string{"server": "admin-server", "app": "app1", "component": "c1"}, value: 1},
+		{name: "weblogic_application_runtimes_collection_size", labels: map[string]string{"server": "admin-server"}, value: 2},
+		{name: "weblogic_component_runtimes_collection_size", labels: map[string]string{"server": "admin-server", "app": "app1"}, value: 3},
+	})
+
+	ItemsDropped.Reset()
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+	if dropped := gatherCounter(t, ItemsDropped); !reflect.DeepEqual(dropped, map[string]float64{"applicationRuntimes": 1, "componentRuntimes": 2}) {
+		t.Errorf("Want 1 application and 2 components dropped, got %v", dropped)
+	}
+}
+
+func TestWildcardFields(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		Children: map[string]MbeanQuery{
+			"JVMRuntime": {
+				MetricPrefix:  "wls_jvm_",
+				Fields:        []string{"*"},
+				ExcludeFields: []string{"heapSizeMax"},
+			},
+		},
+	}
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	queryJSON, err := e.GetRESTQueryJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantQuery := `{"fields":["name"],"children":{"JVMRuntime":{"links":[]}},"links":[]}`
+	if string(queryJSON) != wantQuery {
+		t.Errorf("Want %s\nGot %s\n", wantQuery, queryJSON)
+	}
+
+	apiResponse := `{"name":"admin-server","JVMRuntime":{"identity":["JVMRuntime"],"heapFreeCurrent":99662296,"heapSizeMax":477626368,"javaVendor":"Oracle Corporation","overallHealthState":{"state":"ok"}}}`
+	resp := WeblogicAPIResponse{}
+	if err := json.Unmarshal([]byte(apiResponse), &resp); err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []metricTestSpec{
+		{name: "wls_jvm_heap_free_current", labels: map[string]string{"server": "admin-server"}, value: 99662296},
+	}
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+}
+
+func TestUnmarshalBooleans(t *testing.T) {
+	resp := WeblogicAPIResponse{}
+	if err := json.Unmarshal([]byte(`{"active":true,"paused":false}`), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"active": 1, "paused": 0}
+	if !reflect.DeepEqual(want, resp.NumericalFields) {
+		t.Errorf("Want %v\nGot %v\n", want, resp.NumericalFields)
+	}
+}
+
+func TestDerivedMetrics(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		Children: map[string]MbeanQuery{
+			"JVMRuntime": {
+				MetricPrefix: "wls_jvm_",
+				Fields:       []string{"heapFreeCurrent"},
+				Derived: []DerivedMetric{
+					{Name: "heap_used", Expr: "heapSizeCurrent - heapFreeCurrent"},
+					{Name: "heap_used_ratio", Expr: "(heapSizeCurrent - heapFreeCurrent) / heapSizeMax"},
+					{Name: "missing", Expr: "heapFreeCurrent * notReturned"},
+				},
+			},
+		},
+	}
+	resp := WeblogicAPIResponse{
+		StringFields: map[string]string{"name": "admin-server"},
+		Children: map[string]*WeblogicAPIResponse{
+			"JVMRuntime": {
+				NumericalFields: map[string]float64{"heapFreeCurrent": 100, "heapSizeCurrent": 400, "heapSizeMax": 0},
+			},
+		},
+	}
+	want := sortMetricSpecs([]metricTestSpec{
+		{name: "wls_jvm_heap_free_current", labels: map[string]string{"server": "admin-server"}, value: 100},
+		{name: "wls_jvm_heap_used", labels: map[string]string{"server": "admin-server"}, value: 300},
+	})
+
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantFields := []string{"heapFreeCurrent", "heapSizeCurrent", "heapSizeMax", "notReturned"}
+	if fields := e.query.Children["JVMRuntime"].Fields; !reflect.DeepEqual(wantFields, fields) {
+		t.Errorf("Want fields %v\nGot %v\n", wantFields, fields)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+}
+
+func TestParseExpression(t *testing.T) {
+	fields := map[string]float64{"a": 6, "b": 2}
+	for expr, want := range map[string]float64{
+		"a + b * 2":   10,
+		"(a + b) * 2": 16,
+		"-a / b":      -3,
+		"a - -b":      8,
+		"100 * b / a": 100 * 2.0 / 6,
+	} {
+		e, err := parseExpression(expr)
+		if err != nil {
+			t.Fatalf("%s: %s", expr, err)
+		}
+		if got, ok := e.eval(fields); !ok || got != want {
+			t.Errorf("%s: Want %v\nGot %v\n", expr, want, got)
+		}
+	}
+	for _, expr := range []string{"", "a +", "(a", "a b", "a % b"} {
+		if _, err := parseExpression(expr); err == nil {
+			t.Errorf("Expected an error parsing %q", expr)
+		}
+	}
+}
+
+func TestAggregations(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "component_runtime",
+		LabelValueAttribute: "name",
+		Children: map[string]MbeanQuery{
+			"servlets": {
+				LabelName:           "servlet",
+				LabelValueAttribute: "servletName",
+				MetricPrefix:        "wls_servlet_",
+				Aggregate: []Aggregation{
+					{Field: "invocationTotalCount", Op: "sum"},
+					{Field: "executionTimeHigh", Op: "max"},
+					{Field: "executionTimeAverage", Op: "avg", Name: "execution_time_mean"},
+					{Op: "count"},
+				},
+				AggregateOnly: true,
+			},
+		},
+	}
+	resp := WeblogicAPIResponse{
+		StringFields: map[string]string{"name": "admin-server_/console"},
+		Children: map[string]*WeblogicAPIResponse{
+			"servlets": {
+				Items: []*WeblogicAPIResponse{
+					{
+						StringFields:    map[string]string{"servletName": "JspServlet"},
+						NumericalFields: map[string]float64{"executionTimeHigh": 0, "invocationTotalCount": 0, "executionTimeAverage": 0},
+					},
+					{
+						StringFields:    map[string]string{"servletName": "/login/LoginForm.jsp"},
+						NumericalFields: map[string]float64{"executionTimeHigh": 57, "invocationTotalCount": 1, "executionTimeAverage": 57},
+					},
+				},
+			},
+		},
+	}
+	labels := map[string]string{"component_runtime": "admin-server_/console"}
+	want := sortMetricSpecs([]metricTestSpec{
+		{name: "wls_servlet_invocation_total_count_sum", labels: labels, value: 1},
+		{name: "wls_servlet_execution_time_high_max", labels: labels, value: 57},
+		{name: "wls_servlet_execution_time_mean", labels: labels, value: 28.5},
+		{name: "wls_servlet_servlets_count", labels: labels, value: 2},
+		{name: "weblogic_servlets_collection_size", labels: labels, value: 2},
+	})
+
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+}
+
+func TestDuplicateAggregations(t *testing.T) {
+	for _, tc := range []struct {
+		q       MbeanQuery
+		wantErr string
+	}{
+		{
+			q: MbeanQuery{
+				Children: map[string]MbeanQuery{
+					"servlets": {
+						Aggregate: []Aggregation{{Field: "invocationTotalCount", Op: "sum", Name: "invocations"}, {Field: "executionTimeTotal", Op: "sum", Name: "invocations"}},
+					},
+				},
+			},
+			wantErr: "Metric invocations is defined twice on mBean servlets",
+		},
+		{
+			q: MbeanQuery{
+				Children: map[string]MbeanQuery{
+					"applicationRuntimes": {
+						Aggregate: []Aggregation{{Op: "count", Name: "items"}},
+						Children: map[string]MbeanQuery{
+							"componentRuntimes": {Aggregate: []Aggregation{{Op: "count", Name: "items"}}},
+						},
+					},
+				},
+			},
+			wantErr: "Metric items is defined on both mBean applicationRuntimes and mBean componentRuntimes. Set a name or metric_prefix to tell them apart",
+		},
+	} {
+		_, err := New(tc.q, Options{})
+		if err == nil || err.Error() != tc.wantErr {
+			t.Errorf("Want error %q\nGot %v\n", tc.wantErr, err)
+		}
+	}
+}
+
+func TestInfoMetric(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		Fields:              []string{"openSocketsCurrentCount"},
+		Info: InfoMetric{
+			Name:   "weblogic_server_info",
+			Fields: []string{"weblogicVersion", "listenPort", "clusterName"},
+		},
+	}
+	resp := WeblogicAPIResponse{
+		StringFields:    map[string]string{"name": "admin-server", "weblogicVersion": "12.2.1.4.0"},
+		NumericalFields: map[string]float64{"listenPort": 7001, "openSocketsCurrentCount": 3},
+	}
+	want := sortMetricSpecs([]metricTestSpec{
+		{
+			name:   "open_sockets_current_count",
+			labels: map[string]string{"server": "admin-server"},
+			value:  3,
+		},
+		{
+			name:   "weblogic_server_info",
+			labels: map[string]string{"server": "admin-server", "weblogic_version": "12.2.1.4.0", "listen_port": "7001", "cluster_name": ""},
+			value:  1,
+		},
+	})
+
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+}
+
+func TestInfoMetricNames(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		Info:                InfoMetric{Fields: []string{"weblogicVersion"}},
+		Children: map[string]MbeanQuery{
+			"JVMRuntime": {Info: InfoMetric{Fields: []string{"javaVersion"}}},
+		},
+	}
+	resp := WeblogicAPIResponse{
+		StringFields: map[string]string{"name": "admin-server", "weblogicVersion": "12.2.1.4.0"},
+		Children: map[string]*WeblogicAPIResponse{
+			"JVMRuntime": {StringFields: map[string]string{"javaVersion": "1.8.0_251"}},
+		},
+	}
+	want := sortMetricSpecs([]metricTestSpec{
+		{name: "weblogic_server_runtime_info", labels: map[string]string{"server": "admin-server", "weblogic_version": "12.2.1.4.0"}, value: 1},
+		{name: "weblogic_jvm_runtime_info", labels: map[string]string{"server": "admin-server", "java_version": "1.8.0_251"}, value: 1},
+	})
+
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+}
+
+func TestInvalidInfoMetrics(t *testing.T) {
+	for _, tc := range []struct {
+		q       MbeanQuery
+		wantErr string
+	}{
+		{
+			q: MbeanQuery{
+				Children: map[string]MbeanQuery{
+					"JVMRuntime":        {MetricPrefix: "jvm_", Info: InfoMetric{Fields: []string{"javaVersion"}}},
+					"threadPoolRuntime": {MetricPrefix: "jvm_", Info: InfoMetric{Fields: []string{"name"}}},
+				},
+			},
+			wantErr: "Metric jvm_info is defined on both mBean JVMRuntime and mBean threadPoolRuntime. Set a name or metric_prefix to tell them apart",
+		},
+		{
+			q: MbeanQuery{
+				LabelName:           "server",
+				LabelValueAttribute: "name",
+				Children: map[string]MbeanQuery{
+					"JVMRuntime": {Info: InfoMetric{Fields: []string{"server"}}},
+				},
+			},
+			wantErr: "Info field server on mBean JVMRuntime clashes with the label server of mBean serverRuntime",
+		},
+		{
+			q: MbeanQuery{
+				LabelName:           "server",
+				LabelValueAttribute: "name",
+				Fields:              []string{"openSocketsCurrentCount"},
+				Info:                InfoMetric{Fields: []string{"server"}},
+			},
+			wantErr: "Info field server on mBean serverRuntime clashes with the mBean's label server",
+		},
+	} {
+		_, err := New(tc.q, Options{})
+		if err == nil || err.Error() != tc.wantErr {
+			t.Errorf("Want error %q\nGot %v\n", tc.wantErr, err)
+		}
+	}
+}
+
+func TestTimeFieldTypes(t *testing.T) {
+	now = func() time.Time { return time.Unix(1600000100, 0) }
+	defer func() { now = time.Now }()
+
+	q := MbeanQuery{
+		LabelName:           "application_runtime",
+		LabelValueAttribute: "name",
+		Fields:              []string{"activationTime", "uptime", "deactivationTime"},
+		FieldTypes:          map[string]string{"activationTime": "timestamp_ms", "deactivationTime": "timestamp_ms", "uptime": "duration_ms"},
+		TimestampAge:        true,
+	}
+	resp := WeblogicAPIResponse{
+		StringFields:    map[string]string{"name": "console"},
+		NumericalFields: map[string]float64{"activationTime": 1600000000000, "deactivationTime": 0, "uptime": 1500},
+	}
+	labels := map[string]string{"application_runtime": "console"}
+	want := sortMetricSpecs([]metricTestSpec{
+		{name: "activation_time_seconds", labels: labels, value: 1600000000},
+		{name: "activation_time_seconds_ago", labels: labels, value: 100},
+		{name: "deactivation_time_seconds", labels: labels, value: 0},
+		{name: "uptime_seconds", labels: labels, value: 1.5},
+	})
+
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+}
+
+func TestUnknownStringValues(t *testing.T) {
+	resp := WeblogicAPIResponse{
+		StringFields: map[string]string{"name": "admin-server", "state": "FORCE_SUSPENDING"},
+	}
+	labelsFor := func(state string) map[string]string {
+		return map[string]string{"server": "admin-server", "state": state}
+	}
+	for _, tc := range []struct {
+		policy  string
+		metrics []metricTestSpec
+	}{
+		{
+			policy: "ignore",
+			metrics: []metricTestSpec{
+				{name: "state", labels: labelsFor("RUNNING"), value: 0},
+				{name: "state", labels: labelsFor("SHUTDOWN"), value: 0},
+			},
+		},
+		{
+			policy: "other",
+			metrics: []metricTestSpec{
+				{name: "state", labels: labelsFor("RUNNING"), value: 0},
+				{name: "state", labels: labelsFor("SHUTDOWN"), value: 0},
+				{name: "state", labels: labelsFor("other"), value: 1},
+			},
+		},
+		{
+			policy: "label",
+			metrics: []metricTestSpec{
+				{name: "state", labels: labelsFor("RUNNING"), value: 0},
+				{name: "state", labels: labelsFor("SHUTDOWN"), value: 0},
+				{name: "state", labels: labelsFor("FORCE_SUSPENDING"), value: 1},
+			},
+		},
+	} {
+		q := MbeanQuery{
+			LabelName:           "server",
+			LabelValueAttribute: "name",
+			StringFields: []StringField{
+				{Name: "state", ValueSet: []string{"RUNNING", "SHUTDOWN"}, UnknownValues: tc.policy},
+			},
+		}
+		e, err := New(q, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		genMetrics, err := e.CreateMetrics(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := sortMetricSpecs(tc.metrics)
+		got := gatherMetricSpecs(t, genMetrics)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("%s: Want %v\nGot %v\n", tc.policy, want, got)
+		}
+	}
+
+	// Values are counted each time they're seen, but only logged the first time
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		StringFields: []StringField{
+			{Name: "state", ValueSet: []string{"RUNNING", "SHUTDOWN"}, UnknownValues: "count"},
+		},
+	}
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := e.CreateMetrics(&resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(UnknownStringValues)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(families) != 1 || len(families[0].GetMetric()) != 1 || families[0].GetMetric()[0].GetCounter().GetValue() != 2 {
+		t.Errorf("count: Want 2 unknown values counted, got %v", families)
+	}
+	if n := strings.Count(logged.String(), `returned value "FORCE_SUSPENDING"`); n != 1 {
+		t.Errorf("count: Want the unknown value logged once, got %d times in %q", n, logged.String())
+	}
+
+	// An other series can't be added when other is already a known value
+	q.StringFields[0] = StringField{Name: "state", ValueSet: []string{"RUNNING", "other"}, UnknownValues: "other"}
+	if _, err := New(q, Options{}); err == nil {
+		t.Error("other: Expected an error for a value_set containing other")
+	}
+}
+
+func TestEmptyCollectionSize(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		Children: map[string]MbeanQuery{
+			"JDBCServiceRuntime": {
+				Children: map[string]MbeanQuery{
+					"JDBCDataSourceRuntimeMBeans": {
+						LabelName:           "datasource",
+						LabelValueAttribute: "name",
+						Fields:              []string{"currCapacity"},
+					},
+				},
+			},
+		},
+	}
+	resp := WeblogicAPIResponse{}
+	if err := json.Unmarshal([]byte(`{"name":"admin-server","JDBCServiceRuntime":{"JDBCDataSourceRuntimeMBeans":{"items":[{}]}}}`), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []metricTestSpec{
+		{name: "weblogic_jdbc_data_source_runtime_m_beans_collection_size", labels: map[string]string{"server": "admin-server"}, value: 0},
+	}
+
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+}
+
+func TestLabelConflicts(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "name",
+		LabelValueAttribute: "name",
+		Children: map[string]MbeanQuery{
+			"servlets": {
+				LabelName:           "name",
+				LabelValueAttribute: "servletName",
+				Fields:              []string{"invocationTotalCount"},
+			},
+		},
+	}
+	resp := WeblogicAPIResponse{
+		StringFields: map[string]string{"name": "admin-server"},
+		Children: map[string]*WeblogicAPIResponse{
+			"servlets": {
+				Items: []*WeblogicAPIResponse{
+					{StringFields: map[string]string{"servletName": "JspServlet"}, NumericalFields: map[string]float64{"invocationTotalCount": 272}},
+				},
+			},
+		},
+	}
+	for _, tc := range []struct {
+		policy    string
+		expectErr bool
+		labels    map[string]string
+	}{
+		{policy: "", expectErr: true},
+		{policy: "error", expectErr: true},
+		{policy: "prefix", labels: map[string]string{"name": "admin-server", "servlets_name": "JspServlet"}},
+		{policy: "child_wins", labels: map[string]string{"name": "JspServlet"}},
+	} {
+		e, err := New(q, Options{LabelConflict: tc.policy})
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("%s: Expected an error for conflicting label names", tc.policy)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		genMetrics, err := e.CreateMetrics(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []metricTestSpec{{name: "invocation_total_count", labels: tc.labels, value: 272}}
+		got := gatherMetricSpecs(t, genMetrics)
+		// Only compare the servlet's metric, not the collection size
+		if !reflect.DeepEqual(want, got[:1]) {
+			t.Errorf("%s: Want %v\nGot %v\n", tc.policy, want, got)
+		}
+	}
+
+	// Under child_wins, items of an ancestor collection whose children share a label value can't be told apart, so
+	// only the first of their series is kept and the rest are counted
+	q = MbeanQuery{
+		Children: map[string]MbeanQuery{
+			"applicationRuntimes": {
+				LabelName:           "name",
+				LabelValueAttribute: "name",
+				Children: map[string]MbeanQuery{
+					"componentRuntimes": {
+						LabelName:           "name",
+						LabelValueAttribute: "name",
+						Fields:              []string{"openSessionsCurrentCount"},
+					},
+				},
+			},
+		},
+	}
+	app := func(name string, sessions float64) *WeblogicAPIResponse {
+		return &WeblogicAPIResponse{
+			StringFields: map[string]string{"name": name},
+			Children: map[string]*WeblogicAPIResponse{
+				"componentRuntimes": {Items: []*WeblogicAPIResponse{
+					{StringFields: map[string]string{"name": "c"}, NumericalFields: map[string]float64{"openSessionsCurrentCount": sessions}},
+				}},
+			},
+		}
+	}
+	resp = WeblogicAPIResponse{
+		Children: map[string]*WeblogicAPIResponse{
+			"applicationRuntimes": {Items: []*WeblogicAPIResponse{app("app1", 1), app("app2", 2)}},
+		},
+	}
+	DuplicateSeriesDropped.Reset()
+	e, err := New(q, Options{LabelConflict: "child_wins"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := sortMetricSpecs([]metricTestSpec{
+		{name: "open_sessions_current_count", labels: map[string]string{"name": "c"}, value: 1},
+		{name: "weblogic_application_runtimes_collection_size", labels: map[string]string{}, value: 2},
+		{name: "weblogic_component_runtimes_collection_size", labels: map[string]string{"name": "app1"}, value: 1},
+		{name: "weblogic_component_runtimes_collection_size", labels: map[string]string{"name": "app2"}, value: 1},
+	})
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("child_wins duplicates: Want %v\nGot %v\n", want, got)
+	}
+	if dropped := gatherCounter(t, DuplicateSeriesDropped); !reflect.DeepEqual(dropped, map[string]float64{"applicationRuntimes": 1}) {
+		t.Errorf("child_wins duplicates: Want 1 series dropped, got %v", dropped)
+	}
+
+	// Other duplicates are a config error, so they're left to fail registration rather than silently dropped
+	q = MbeanQuery{
+		Children: map[string]MbeanQuery{
+			"applicationRuntimes": {LabelName: "app", LabelValueAttribute: "name", Fields: []string{"healthState"}},
+		},
+	}
+	resp = WeblogicAPIResponse{
+		Children: map[string]*WeblogicAPIResponse{
+			"applicationRuntimes": {Items: []*WeblogicAPIResponse{
+				{StringFields: map[string]string{"name": "app1"}, NumericalFields: map[string]float64{"healthState": 0}},
+				{StringFields: map[string]string{"name": "app1"}, NumericalFields: map[string]float64{"healthState": 1}},
+			}},
+		},
+	}
+	e, err = New(q, Options{LabelConflict: "child_wins"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err = e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both items' series plus the collection size
+	if len(genMetrics) != 3 {
+		t.Errorf("Want duplicates of a labelled collection kept, got %d metrics", len(genMetrics))
+	}
+}
+
+// Items of a collection without a label_name can't be told apart, so only the first of their series is kept and the
+// rest are counted.
+func TestUnlabelledCollectionDuplicates(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		Children: map[string]MbeanQuery{
+			"applicationRuntimes": {Fields: []string{"healthState"}},
+		},
+	}
+	resp := WeblogicAPIResponse{
+		StringFields: map[string]string{"name": "admin-server"},
+		Children: map[string]*WeblogicAPIResponse{
+			"applicationRuntimes": {Items: []*WeblogicAPIResponse{
+				{StringFields: map[string]string{"name": "app1"}, NumericalFields: map[string]float64{"healthState": 0}},
+				{StringFields: map[string]string{"name": "app2"}, NumericalFields: map[string]float64{"healthState": 1}},
+			}},
+		},
+	}
+	labels := map[string]string{"server": "admin-server"}
+	want := sortMetricSpecs([]metricTestSpec{
+		{name: "health_state", labels: labels, value: 0},
+		{name: "weblogic_application_runtimes_collection_size", labels: labels, value: 2},
+	})
+
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	DuplicateSeriesDropped.Reset()
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+	if dropped := gatherCounter(t, DuplicateSeriesDropped); !reflect.DeepEqual(dropped, map[string]float64{"applicationRuntimes": 1}) {
+		t.Errorf("Want 1 series dropped, got %v", dropped)
+	}
+	if !strings.Contains(logged.String(), "Set a label_name to tell its items apart") {
+		t.Errorf("Want the dropped series logged, got %q", logged.String())
+	}
+}
+
+func TestNaming(t *testing.T) {
+	q := MbeanQuery{
+		MetricPrefix: "jvm_",
+		Fields:       []string{"heapFreePercent", "uptime", "acceptTotalCount", "JMSServersCurrentCount"},
+		FieldTypes:   map[string]string{"uptime": "duration_ms"},
+		Derived:      []DerivedMetric{{Name: "heapUsedPercent", Expr: "100 - heapFreePercent"}},
+		Children:     map[string]MbeanQuery{"JDBCDataSourceRuntimeMBeans": {}},
+	}
+	resp := WeblogicAPIResponse{
+		NumericalFields: map[string]float64{"heapFreePercent": 40, "uptime": 5000, "acceptTotalCount": 12, "JMSServersCurrentCount": 2},
+		Children:        map[string]*WeblogicAPIResponse{"JDBCDataSourceRuntimeMBeans": {Items: []*WeblogicAPIResponse{}}},
+	}
+	for _, tc := range []struct {
+		naming Naming
+		want   []metricTestSpec
+	}{
+		{
+			naming: Naming{},
+			want: []metricTestSpec{
+				{name: "jvm_accept_total_count", labels: map[string]string{}, value: 12},
+				{name: "jvm_heap_free_percent", labels: map[string]string{}, value: 40},
+				{name: "jvm_heap_used_percent", labels: map[string]string{}, value: 60},
+				{name: "jvm_jms_servers_current_count", labels: map[string]string{}, value: 2},
+				{name: "jvm_uptime_seconds", labels: map[string]string{}, value: 5},
+				{name: "weblogic_jdbc_data_source_runtime_m_beans_collection_size", labels: map[string]string{}, value: 0},
+			},
+		},
+		{
+			// The namespace replaces the weblogic prefix of collection sizes
+			naming: Naming{Namespace: "weblogic", EnforceConventions: true},
+			want: []metricTestSpec{
+				{name: "weblogic_jdbc_data_source_runtime_m_beans_collection_size", labels: map[string]string{}, value: 0},
+				{name: "weblogic_jvm_accept_total", labels: map[string]string{}, value: 12},
+				{name: "weblogic_jvm_heap_free_ratio", labels: map[string]string{}, value: 0.4},
+				{name: "weblogic_jvm_heap_used_percent", labels: map[string]string{}, value: 60},
+				{name: "weblogic_jvm_jms_servers_current_count", labels: map[string]string{}, value: 2},
+				{name: "weblogic_jvm_uptime_seconds", labels: map[string]string{}, value: 5},
+			},
+		},
+		{
+			// Camel case keeps attribute names exactly as Weblogic returns them, including leading acronyms
+			naming: Naming{Case: "camel", EnforceConventions: true},
+			want: []metricTestSpec{
+				{name: "jvm_JMSServersCurrentCount", labels: map[string]string{}, value: 2},
+				{name: "jvm_accept_total", labels: map[string]string{}, value: 12},
+				{name: "jvm_heapFree_ratio", labels: map[string]string{}, value: 0.4},
+				{name: "jvm_heapUsedPercent", labels: map[string]string{}, value: 60},
+				{name: "jvm_uptime_seconds", labels: map[string]string{}, value: 5},
+				{name: "weblogic_JDBCDataSourceRuntimeMBeans_collection_size", labels: map[string]string{}, value: 0},
+			},
+		},
+	} {
+		e, err := New(q, Options{Naming: tc.naming})
+		if err != nil {
+			t.Fatal(err)
+		}
+		genMetrics, err := e.CreateMetrics(&resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := gatherMetricSpecs(t, genMetrics)
+		if !reflect.DeepEqual(tc.want, got) {
+			t.Errorf("%+v: Want %v\nGot %v\n", tc.naming, tc.want, got)
+		}
+	}
+
+	// Counters suffixed _total under the conventions are typed as counters rather than gauges
+	e, err := New(q, Options{Naming: Naming{EnforceConventions: true}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry := prometheus.NewRegistry()
+	for _, m := range genMetrics {
+		registry.MustRegister(m)
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		wantType := "GAUGE"
+		if family.GetName() == "jvm_accept_total" {
+			wantType = "COUNTER"
+		}
+		if family.GetType().String() != wantType {
+			t.Errorf("%s: Want type %s, got %s", family.GetName(), wantType, family.GetType())
+		}
+	}
+
+	for _, naming := range []Naming{{Namespace: "weblogic-prod"}, {Case: "kebab"}} {
+		if _, err := New(q, Options{Naming: naming}); err == nil {
+			t.Errorf("%+v: Expected an error for invalid naming", naming)
+		}
+	}
+}
+
+// Empty arrays are attributes, so they mustn't turn the mBean holding them into a collection.
+func TestEmptyArrayAttributes(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		Fields:              []string{"deploymentTargets"},
+		FieldTypes:          map[string]string{"deploymentTargets": "length"},
+		Info:                InfoMetric{Name: "weblogic_server_info", Fields: []string{"weblogicVersion"}},
+	}
+	resp := WeblogicAPIResponse{}
+	if err := json.Unmarshal([]byte(`{"name":"admin-server","weblogicVersion":"12.2.1.4.0","deploymentTargets":[]}`), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Items != nil {
+		t.Errorf("Want no items, got %v", resp.Items)
+	}
+	want := sortMetricSpecs([]metricTestSpec{
+		{name: "deployment_targets_length", labels: map[string]string{"server": "admin-server"}, value: 0},
+		{name: "weblogic_server_info", labels: map[string]string{"server": "admin-server", "weblogic_version": "12.2.1.4.0"}, value: 1},
+	})
+
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+}
+
+func TestFieldTypeCoercion(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		Fields:              []string{"openSessionsCount", "maxHeap", "timeout", "enabled", "sessions", "broken"},
+		FieldTypes: map[string]string{
+			"openSessionsCount": "number",
+			"maxHeap":           "bytes",
+			"timeout":           "duration",
+			"enabled":           "bool",
+			"broken":            "number",
+		},
+		AbsentValues: map[string][]float64{"openSessionsCount": {-1}, "sessions": {-1}},
+	}
+	resp := WeblogicAPIResponse{
+		StringFields: map[string]string{
+			"name":              "admin-server",
+			"openSessionsCount": "-1",
+			"maxHeap":           "512m",
+			"timeout":           "1m30s",
+			"enabled":           "true",
+			"broken":            "n/a",
+		},
+		NumericalFields: map[string]float64{"sessions": 4},
+	}
+	labels := map[string]string{"server": "admin-server"}
+	want := sortMetricSpecs([]metricTestSpec{
+		{name: "max_heap_bytes", labels: labels, value: 512 * 1024 * 1024},
+		{name: "timeout_seconds", labels: labels, value: 90},
+		{name: "enabled", labels: labels, value: 1},
+		{name: "sessions", labels: labels, value: 4},
+	})
+
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	genMetrics, err := e.CreateMetrics(&resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gatherMetricSpecs(t, genMetrics)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+}
+
+func TestParseTypedString(t *testing.T) {
+	for _, tc := range []struct {
+		fieldType string
+		value     string
+		want      float64
+	}{
+		{"bytes", "2048", 2048},
+		{"bytes", "1.5 GiB", 1.5 * 1024 * 1024 * 1024},
+		{"bytes", "64KB", 64 * 1024},
+		{"duration", "250", 250},
+		{"duration", "2s", 2000},
+		{"number", " 42 ", 42},
+		{"bool", "false", 0},
+	} {
+		got, err := parseTypedString(tc.fieldType, tc.value)
+		if err != nil || got != tc.want {
+			t.Errorf("%s %q: Want %v\nGot %v (%v)\n", tc.fieldType, tc.value, tc.want, got, err)
+		}
+	}
+	if _, err := parseTypedString("bytes", "lots"); err == nil {
+		t.Error("Expected an error parsing an invalid byte size")
+	}
+}
+
+func TestMissingFields(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		Fields:              []string{"openSocketsCurrentCount", "openSocketCount"},
+		Children: map[string]MbeanQuery{
+			"applicationRuntimes": {
+				LabelName:           "app",
+				LabelValueAttribute: "name",
+				Fields:              []string{"healthState", "activeVersionState"},
+			},
+			"JMSRuntime": {
+				Fields: []string{"connectionsCurrentCount"},
+			},
+			"JDBCServiceRuntime": {
+				Children: map[string]MbeanQuery{
+					"JDBCDataSourceRuntimeMBeans": {
+						LabelName:           "datasource",
+						LabelValueAttribute: "name",
+						Fields:              []string{"currCapacity"},
+					},
+				},
+			},
+		},
+	}
+	// activeVersionState is only returned for one application, so it isn't missing
+	resp := WeblogicAPIResponse{}
+	if err := json.Unmarshal([]byte(`{"name":"admin-server","openSocketsCurrentCount":3,
+		"applicationRuntimes":{"items":[{"name":"app1","healthState":{"state":"ok"}},{"name":"app2","activeVersionState":2}]},
+		"JDBCServiceRuntime":{"JDBCDataSourceRuntimeMBeans":{"items":[]}}}`), &resp); err != nil {
+		t.Fatal(err)
+	}
+	want := []metricTestSpec{
+		{name: "weblogic_exporter_missing_field", labels: map[string]string{"mbean": "serverRuntime", "field": "JMSRuntime"}, value: 1},
+		{name: "weblogic_exporter_missing_field", labels: map[string]string{"mbean": "serverRuntime", "field": "openSocketCount"}, value: 1},
+	}
+
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := gatherMetricSpecs(t, e.missingFieldMetrics(&resp))
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("Want %v\nGot %v\n", want, got)
+	}
+}
+
+func TestMetricCache(t *testing.T) {
+	fetched := time.Unix(1600000000, 0)
+	now = func() time.Time { return fetched }
+	defer func() { now = time.Now }()
+
+	cache := NewMetricCache(5 * time.Minute)
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "open_sockets_current_count"})
+	cache.Store("admin:7001", "weblogic", "welcome1", []prometheus.Gauge{gauge})
+
+	now = func() time.Time { return fetched.Add(time.Minute) }
+	metrics, age, ok := cache.Load("admin:7001", "weblogic", "welcome1")
+	if !ok || len(metrics) != 1 || age != time.Minute {
+		t.Errorf("Expected 1 metric aged 1m, got %d metrics aged %s", len(metrics), age)
+	}
+	if _, _, ok := cache.Load("admin:7001", "weblogic", "wrong"); ok {
+		t.Errorf("Expected no metrics for different credentials")
+	}
+
+	now = func() time.Time { return fetched.Add(6 * time.Minute) }
+	if _, _, ok := cache.Load("admin:7001", "weblogic", "welcome1"); ok {
+		t.Errorf("Expected no metrics once they're older than the max age")
+	}
+}
+
+func TestRefreshIntervals(t *testing.T) {
+	q := MbeanQuery{
+		LabelName:           "server",
+		LabelValueAttribute: "name",
+		Fields:              []string{"openSocketsCurrentCount"},
+		Children: map[string]MbeanQuery{
+			"applicationRuntimes": {
+				LabelName:           "app",
+				LabelValueAttribute: "name",
+				Fields:              []string{"activeVersionState"},
+				RefreshInterval:     time.Minute,
+			},
+		},
+	}
+	appRequests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var query struct {
+			Fields   []string
+			Children map[string]json.RawMessage
+		}
+		if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if _, ok := query.Children["applicationRuntimes"]; ok {
+			appRequests++
+			// Ancestors of a separately fetched mBean only return their label attribute
+			if !reflect.DeepEqual([]string{"name"}, query.Fields) {
+				t.Errorf("Want ancestor fields [name]\nGot %v\n", query.Fields)
+			}
+			fmt.Fprintf(w, `{"name":"admin-server","applicationRuntimes":{"items":[{"name":"app1","activeVersionState":%d}]}}`, appRequests)
+			return
+		}
+		fmt.Fprint(w, `{"name":"admin-server","openSocketsCurrentCount":3}`)
+	}))
+	defer server.Close()
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(serverURL.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetched := time.Unix(1600000000, 0)
+	now = func() time.Time { return fetched }
+	defer func() { now = time.Now }()
+
+	e, err := New(q, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		elapsed     time.Duration
+		appRequests int
+	}{
+		{elapsed: 0, appRequests: 1},
+		{elapsed: 30 * time.Second, appRequests: 1},
+		{elapsed: 2 * time.Minute, appRequests: 2},
+	} {
+		now = func() time.Time { return fetched.Add(tc.elapsed) }
+		genMetrics, _, err := e.DoQuery(serverURL.Hostname(), port, "weblogic", "welcome1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []metricTestSpec{
+			{name: "active_version_state", labels: map[string]string{"server": "admin-server", "app": "app1"}, value: float64(tc.appRequests)},
+			{name: "open_sockets_current_count", labels: map[string]string{"server": "admin-server"}, value: 3},
+			{name: "weblogic_application_runtimes_collection_size", labels: map[string]string{"server": "admin-server"}, value: 1},
+		}
+		got := gatherMetricSpecs(t, genMetrics)
+		if !reflect.DeepEqual(want, got) {
+			t.Errorf("After %s: Want %v\nGot %v\n", tc.elapsed, want, got)
+		}
+		if appRequests != tc.appRequests {
+			t.Errorf("After %s: Want %d application requests, got %d", tc.elapsed, tc.appRequests, appRequests)
+		}
+	}
+
+	// An exporter replacing this one on a config reload reuses its cached responses, unless the group's query changed
+	for _, tc := range []struct {
+		name        string
+		appFields   []string
+		appRequests int
+	}{
+		{name: "unchanged query", appFields: []string{"activeVersionState"}, appRequests: 2},
+		{name: "changed query", appFields: []string{"activeVersionState", "healthState"}, appRequests: 3},
+	} {
+		apps := q.Children["applicationRuntimes"]
+		apps.Fields = tc.appFields
+		q.Children["applicationRuntimes"] = apps
+		reloaded, err := New(q, Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		reloaded.ReuseResponseCache(&e)
+		if _, _, err := reloaded.DoQuery(serverURL.Hostname(), port, "weblogic", "welcome1"); err != nil {
+			t.Fatal(err)
+		}
+		if appRequests != tc.appRequests {
+			t.Errorf("Reloaded with %s: Want %d application requests, got %d", tc.name, tc.appRequests, appRequests)
+		}
+	}
+}
+
+// gatherMetricSpecs registers gauges with a fresh registry and returns them as sorted metricTestSpecs,
+// so tests don't depend on the order metrics are generated in.
+func gatherMetricSpecs(t *testing.T, gauges []prometheus.Gauge) []metricTestSpec {
+	registry := prometheus.NewRegistry()
+	for _, g := range gauges {
+		if err := registry.Register(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	specs := []metricTestSpec{}
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			labels := make(map[string]string)
+			for _, lp := range m.GetLabel() {
+				labels[lp.GetName()] = lp.GetValue()
+			}
+			specs = append(specs, metricTestSpec{
+				name:   family.GetName(),
+				labels: labels,
+				value:  m.GetGauge().GetValue() + m.GetCounter().GetValue(),
+			})
+		}
+	}
+	return sortMetricSpecs(specs)
+}
+
+func sortMetricSpecs(specs []metricTestSpec) []metricTestSpec {
+	sorted := make([]metricTestSpec, len(specs))
+	copy(sorted, specs)
+	sort.Slice(sorted, func(i, j int) bool {
+		return fmt.Sprint(sorted[i]) < fmt.Sprint(sorted[j])
+	})
+	return sorted
+}
+
 func prettyPrint(i interface{}) string {
 	s, _ := json.MarshalIndent(i, "", "\t")
 	return string(s)
@@ -0,0 +1,203 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"unicode"
+)
+
+/*
+expression is a parsed arithmetic expression over the numerical attributes of an mBean, used for derived metrics.
+Expressions support numbers, attribute names, parentheses, unary minus and the +, -, * and / operators.
+*/
+type expression interface {
+	// eval evaluates the expression against an mBean's numerical fields. It returns false if a field is missing
+	// or the result isn't a finite number, such as when dividing by zero.
+	eval(fields map[string]float64) (float64, bool)
+	// fields appends the attribute names the expression refers to.
+	fields(names []string) []string
+}
+
+type numberExpr float64
+
+func (n numberExpr) eval(map[string]float64) (float64, bool) { return float64(n), true }
+func (n numberExpr) fields(names []string) []string          { return names }
+
+type fieldExpr string
+
+func (f fieldExpr) eval(fields map[string]float64) (float64, bool) {
+	value, ok := fields[string(f)]
+	return value, ok
+}
+
+func (f fieldExpr) fields(names []string) []string {
+	if stringInSlice(string(f), names) {
+		return names
+	}
+	return append(names, string(f))
+}
+
+type negateExpr struct {
+	operand expression
+}
+
+func (n negateExpr) eval(fields map[string]float64) (float64, bool) {
+	value, ok := n.operand.eval(fields)
+	return -value, ok
+}
+
+func (n negateExpr) fields(names []string) []string { return n.operand.fields(names) }
+
+type binaryExpr struct {
+	op          rune
+	left, right expression
+}
+
+func (b binaryExpr) eval(fields map[string]float64) (float64, bool) {
+	left, ok := b.left.eval(fields)
+	if !ok {
+		return 0, false
+	}
+	right, ok := b.right.eval(fields)
+	if !ok {
+		return 0, false
+	}
+	var result float64
+	switch b.op {
+	case '+':
+		result = left + right
+	case '-':
+		result = left - right
+	case '*':
+		result = left * right
+	case '/':
+		if right == 0 {
+			return 0, false
+		}
+		result = left / right
+	}
+	if math.IsNaN(result) || math.IsInf(result, 0) {
+		return 0, false
+	}
+	return result, true
+}
+
+func (b binaryExpr) fields(names []string) []string {
+	return b.right.fields(b.left.fields(names))
+}
+
+// exprParser is a recursive descent parser for derived metric expressions.
+type exprParser struct {
+	input []rune
+	pos   int
+}
+
+// parseExpression parses an arithmetic expression such as "heapSizeCurrent - heapFreeCurrent".
+func parseExpression(s string) (expression, error) {
+	p := &exprParser{input: []rune(s)}
+	expr, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.input) {
+		return nil, fmt.Errorf("Unexpected %q at position %d in expression %q", p.input[p.pos], p.pos, s)
+	}
+	return expr, nil
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.input) && unicode.IsSpace(p.input[p.pos]) {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space character, or 0 at the end of the input.
+func (p *exprParser) peek() rune {
+	p.skipSpace()
+	if p.pos >= len(p.input) {
+		return 0
+	}
+	return p.input[p.pos]
+}
+
+func (p *exprParser) parseSum() (expression, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseProduct() (expression, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (expression, error) {
+	if p.peek() == '-' {
+		p.pos++
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return negateExpr{operand: operand}, nil
+	}
+	return p.parseOperand()
+}
+
+func (p *exprParser) parseOperand() (expression, error) {
+	c := p.peek()
+	switch {
+	case c == 0:
+		return nil, fmt.Errorf("Unexpected end of expression %q", string(p.input))
+	case c == '(':
+		p.pos++
+		expr, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("Missing closing parenthesis in expression %q", string(p.input))
+		}
+		p.pos++
+		return expr, nil
+	case unicode.IsDigit(c) || c == '.':
+		start := p.pos
+		for p.pos < len(p.input) && (unicode.IsDigit(p.input[p.pos]) || p.input[p.pos] == '.') {
+			p.pos++
+		}
+		value, err := strconv.ParseFloat(string(p.input[start:p.pos]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid number %q in expression %q", string(p.input[start:p.pos]), string(p.input))
+		}
+		return numberExpr(value), nil
+	case unicode.IsLetter(c) || c == '_':
+		start := p.pos
+		for p.pos < len(p.input) && (unicode.IsLetter(p.input[p.pos]) || unicode.IsDigit(p.input[p.pos]) || p.input[p.pos] == '_') {
+			p.pos++
+		}
+		return fieldExpr(p.input[start:p.pos]), nil
+	}
+	return nil, fmt.Errorf("Unexpected %q at position %d in expression %q", c, p.pos, string(p.input))
+}
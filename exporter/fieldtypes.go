@@ -0,0 +1,203 @@
+package exporter
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// Field types that can be given to mBean attributes in the field_types config, controlling how their values are converted.
+const (
+	fieldTypeTimestampMs = "timestamp_ms" // Epoch milliseconds, exported as Unix seconds
+	fieldTypeDurationMs  = "duration_ms"  // A duration in milliseconds, exported as seconds
+	fieldTypeLength      = "length"       // An array of plain values, such as deploymentTargets, exported as its length
+	fieldTypeNumber      = "number"       // A number, which Weblogic may return as a string
+	fieldTypeBytes       = "bytes"        // A size in bytes, or a string with a unit such as 512m, exported in bytes
+	fieldTypeDuration    = "duration"     // A duration in milliseconds, or a string with a unit such as 30s, exported as seconds
+	fieldTypeBool        = "bool"         // A boolean, which Weblogic may return as a string, exported as 1 or 0
+)
+
+var fieldTypes = []string{
+	fieldTypeTimestampMs, fieldTypeDurationMs, fieldTypeLength, fieldTypeNumber, fieldTypeBytes, fieldTypeDuration, fieldTypeBool,
+}
+
+// now returns the current time. It's a variable so tests can fix the time used for timestamp ages.
+var now = time.Now
+
+// validateFieldTypes checks that every field in a field_types config has a known type.
+func validateFieldTypes(beanName string, types map[string]string) error {
+	for field, fieldType := range types {
+		if !stringInSlice(fieldType, fieldTypes) {
+			return fmt.Errorf("Unknown type %q for field %s on mBean %s. Must be one of %s",
+				fieldType, field, beanName, strings.Join(fieldTypes, ", "))
+		}
+	}
+	return nil
+}
+
+// byteSizePattern matches sizes such as 512m, 1.5 GiB or 2048.
+var byteSizePattern = regexp.MustCompile(`^(?i)([0-9]*\.?[0-9]+)\s*([kmgtp]?)(i?b)?$`)
+
+// parseTypedString parses a string attribute into a number according to its field type. Values are returned in the
+// same unit Weblogic uses for the numeric form of the type, such as milliseconds for durations.
+func parseTypedString(fieldType, value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	switch fieldType {
+	case fieldTypeNumber, fieldTypeTimestampMs, fieldTypeDurationMs:
+		return strconv.ParseFloat(value, 64)
+	case fieldTypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return 0, err
+		}
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+	case fieldTypeBytes:
+		match := byteSizePattern.FindStringSubmatch(value)
+		if match == nil {
+			return 0, fmt.Errorf("Invalid byte size %q", value)
+		}
+		size, err := strconv.ParseFloat(match[1], 64)
+		if err != nil {
+			return 0, err
+		}
+		if match[2] == "" {
+			return size, nil
+		}
+		exponent := strings.Index("kmgtp", strings.ToLower(match[2])) + 1
+		return size * math.Pow(1024, float64(exponent)), nil
+	case fieldTypeDuration:
+		// Plain numbers are milliseconds, as Weblogic returns for numeric durations
+		if ms, err := strconv.ParseFloat(value, 64); err == nil {
+			return ms, nil
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, err
+		}
+		return float64(d) / float64(time.Millisecond), nil
+	}
+	return 0, fmt.Errorf("Field type %s can't be parsed from a string", fieldType)
+}
+
+// numericalFields returns an mBean's numerical attributes, including string attributes parsed according to their
+// field type, and leaving out any attributes set to one of their absent values.
+func (c MBeanConfig) numericalFields(resp *WeblogicAPIResponse) map[string]float64 {
+	if len(c.FieldTypes) == 0 && len(c.AbsentValues) == 0 {
+		return resp.NumericalFields
+	}
+	fields := make(map[string]float64, len(resp.NumericalFields))
+	for fieldName, value := range resp.NumericalFields {
+		fields[fieldName] = value
+	}
+	for fieldName, fieldType := range c.FieldTypes {
+		s, ok := resp.StringFields[fieldName]
+		if !ok {
+			continue
+		}
+		// Values that can't be parsed are skipped, as if Weblogic hadn't returned them
+		if value, err := parseTypedString(fieldType, s); err == nil {
+			fields[fieldName] = value
+		}
+	}
+	for fieldName, absentValues := range c.AbsentValues {
+		value, ok := fields[fieldName]
+		if !ok {
+			continue
+		}
+		for _, absent := range absentValues {
+			if value == absent {
+				delete(fields, fieldName)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// metricName returns the name of the metric exported for an mBean attribute, adding a unit suffix if it has one.
+// Attributes without a unit get the suffix required by the naming conventions, if they're enforced.
+func (c MBeanConfig) metricName(fieldName, unit string) string {
+	if unit == "" {
+		fieldName, unit, _ = c.Naming.conventionSuffix(fieldName)
+	}
+	name := c.prefixedName(c.Naming.convertCase(fieldName))
+	if unit != "" && !strings.HasSuffix(name, "_"+unit) {
+		name += "_" + unit
+	}
+	return name
+}
+
+// prefixedName prepends the namespace and the mBean's metric prefix to a metric name.
+func (c MBeanConfig) prefixedName(name string) string {
+	return c.Naming.withNamespace(c.MetricPrefix + name)
+}
+
+// beanMetricName returns the name of a metric about the mBean as a whole, such as its info metric. Without a metric
+// prefix it's named after the mBean, so mBeans without prefixes don't share the name.
+func (c MBeanConfig) beanMetricName(beanName, name string) string {
+	if c.MetricPrefix != "" {
+		return c.prefixedName(name)
+	}
+	return c.Naming.beanMetricName(beanName, name)
+}
+
+// typedMetric is a metric value converted according to its attribute's field type.
+type typedMetric struct {
+	name    string
+	value   float64
+	counter bool // Whether the metric is a cumulative counter, named with a _total suffix
+}
+
+/*
+counterGauge is a gauge exported as a counter. Metrics are built as gauges so their values can be set, but cumulative
+attributes named with a _total suffix must be typed as counters to follow the naming conventions.
+*/
+type counterGauge struct {
+	prometheus.Gauge
+}
+
+// Write implements prometheus.Metric, writing the gauge's value as a counter's.
+func (c counterGauge) Write(m *dto.Metric) error {
+	if err := c.Gauge.Write(m); err != nil {
+		return err
+	}
+	m.Counter = &dto.Counter{Value: m.Gauge.Value}
+	m.Gauge = nil
+	return nil
+}
+
+// Collect implements prometheus.Collector, collecting the counter rather than the gauge it wraps.
+func (c counterGauge) Collect(ch chan<- prometheus.Metric) {
+	ch <- c
+}
+
+// convertField converts a numerical attribute according to its configured field type, returning the metrics to export.
+// Timestamps may produce an extra metric for the time elapsed since them.
+func (c MBeanConfig) convertField(fieldName string, value float64) []typedMetric {
+	switch c.FieldTypes[fieldName] {
+	case fieldTypeTimestampMs:
+		seconds := value / 1000
+		converted := []typedMetric{{name: c.metricName(fieldName, "seconds"), value: seconds}}
+		// A zero timestamp means the event hasn't happened, such as an application that was never activated
+		if c.TimestampAge && value > 0 {
+			age := float64(now().UnixNano())/1e9 - seconds
+			converted = append(converted, typedMetric{name: c.metricName(fieldName, "seconds_ago"), value: age})
+		}
+		return converted
+	case fieldTypeDurationMs, fieldTypeDuration:
+		return []typedMetric{{name: c.metricName(fieldName, "seconds"), value: value / 1000}}
+	case fieldTypeBytes:
+		return []typedMetric{{name: c.metricName(fieldName, "bytes"), value: value}}
+	}
+	_, suffix, scale := c.Naming.conventionSuffix(fieldName)
+	return []typedMetric{{name: c.metricName(fieldName, ""), value: value * scale, counter: suffix == "total"}}
+}
@@ -0,0 +1,137 @@
+package exporter
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/benridley/wls_go/wls"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// missingFieldLogInterval is how often a field or child mBean missing from Weblogic's responses is logged.
+const missingFieldLogInterval = time.Hour
+
+// throttledLog logs messages at most once per interval for each key.
+type throttledLog struct {
+	mu         sync.Mutex
+	interval   time.Duration
+	lastLogged map[string]time.Time
+}
+
+func newThrottledLog(interval time.Duration) *throttledLog {
+	return &throttledLog{interval: interval, lastLogged: make(map[string]time.Time)}
+}
+
+// Printf logs the message unless a message with the same key was logged within the interval.
+func (l *throttledLog) Printf(key, format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if last, ok := l.lastLogged[key]; ok && now().Sub(last) < l.interval {
+		return
+	}
+	l.lastLogged[key] = now()
+	log.Printf(format, v...)
+}
+
+// missingFieldMetrics compares the query sent to Weblogic with its response, returning a metric for each configured
+// field or child mBean that wasn't returned, and logging them. They're keyed by their mBean's path in the tree.
+func (e *Exporter) missingFieldMetrics(resp *WeblogicAPIResponse) []prometheus.Gauge {
+	missing := make(map[string][]string)
+	findMissingFields("serverRuntime", &e.query, []*WeblogicAPIResponse{resp}, missing)
+
+	paths := make([]string, 0, len(missing))
+	for path := range missing {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var gauges []prometheus.Gauge
+	for _, path := range paths {
+		for _, field := range missing[path] {
+			if e.missingFieldLog != nil {
+				e.missingFieldLog.Printf(path+"/"+field, "Weblogic did not return %s configured on mBean %s. Check it's spelled correctly and exists in this Weblogic version", field, path)
+			}
+			gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+				Name:        "weblogic_exporter_missing_field",
+				Help:        "Configured fields and child mBeans that Weblogic did not return",
+				ConstLabels: prometheus.Labels{"mbean": path, "field": field},
+			})
+			gauge.Set(1)
+			gauges = append(gauges, gauge)
+		}
+	}
+	return gauges
+}
+
+/*
+findMissingFields records the fields and children of a query that are absent from every instance of the mBean in the
+responses. Collections are compared item by item, and a field only counts as missing if no item returned it, as
+Weblogic leaves out attributes that don't apply to some items. Empty collections aren't compared at all.
+*/
+func findMissingFields(path string, query *wls.WLSRestQuery, resps []*WeblogicAPIResponse, missing map[string][]string) {
+	var instances []*WeblogicAPIResponse
+	for _, resp := range resps {
+		if resp.Items != nil {
+			instances = append(instances, resp.Items...)
+		} else {
+			instances = append(instances, resp)
+		}
+	}
+	if len(instances) == 0 {
+		return
+	}
+
+	for _, field := range query.Fields {
+		returned := false
+		for _, instance := range instances {
+			if instance.hasAttribute(field) {
+				returned = true
+				break
+			}
+		}
+		if !returned {
+			missing[path] = append(missing[path], field)
+		}
+	}
+
+	childNames := make([]string, 0, len(query.Children))
+	for childName := range query.Children {
+		childNames = append(childNames, childName)
+	}
+	sort.Strings(childNames)
+	for _, childName := range childNames {
+		var children []*WeblogicAPIResponse
+		for _, instance := range instances {
+			if child, ok := instance.Children[childName]; ok {
+				children = append(children, child)
+			}
+		}
+		if len(children) == 0 {
+			missing[path] = append(missing[path], childName)
+			continue
+		}
+		findMissingFields(path+"/"+childName, query.Children[childName], children, missing)
+	}
+}
+
+// hasAttribute reports whether the response contains an attribute of any type.
+func (w *WeblogicAPIResponse) hasAttribute(name string) bool {
+	if _, ok := w.NumericalFields[name]; ok {
+		return true
+	}
+	if _, ok := w.StringFields[name]; ok {
+		return true
+	}
+	if _, ok := w.ObjectFields[name]; ok {
+		return true
+	}
+	if _, ok := w.ArrayLengths[name]; ok {
+		return true
+	}
+	if _, ok := w.Children[name]; ok {
+		return true
+	}
+	return w.NullFields[name]
+}
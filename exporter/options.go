@@ -0,0 +1,130 @@
+package exporter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/iancoleman/strcase"
+)
+
+// Options contains exporter wide settings that apply across the whole mBean tree.
+type Options struct {
+	LabelConflict string `yaml:"label_conflict,omitempty"` // How to resolve a child mBean using the same label_name as an ancestor
+	Naming        Naming `yaml:"naming,omitempty"`         // How metric names are built from mBean attribute names
+}
+
+// Naming controls how the names of mBean metrics are built from their attribute names.
+type Naming struct {
+	Namespace          string `yaml:"namespace,omitempty"`           // Prepended to every mBean metric name, followed by an underscore
+	Case               string `yaml:"case,omitempty"`                // How attribute names are converted, snake or camel. Defaults to snake
+	EnforceConventions bool   `yaml:"enforce_conventions,omitempty"` // Whether to apply the _total and _ratio suffix conventions. Units come from field_types
+}
+
+// Cases for converting attribute names to metric names, set with naming.case in config.
+const (
+	namingCaseSnake = "snake" // openSocketsCurrentCount becomes open_sockets_current_count. This is the default
+	namingCaseCamel = "camel" // Attribute names are kept exactly as Weblogic returns them, e.g. JMSServersCurrentCount
+)
+
+// Valid Prometheus metric and label names, used to check names given in config.
+var (
+	metricNameRegex = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegex  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// Policies for resolving a child mBean's label_name clashing with an ancestor's, set with label_conflict in config.
+const (
+	labelConflictError     = "error"      // Refuse to load the config. This is the default
+	labelConflictPrefix    = "prefix"     // Prefix the child's label with its snake case mBean name, e.g. servlets_name
+	labelConflictChildWins = "child_wins" // Keep the label name, with the child's value replacing the ancestor's
+)
+
+// validate checks the options contain known values.
+func (o Options) validate() error {
+	switch o.LabelConflict {
+	case "", labelConflictError, labelConflictPrefix, labelConflictChildWins:
+	default:
+		return fmt.Errorf("Unknown label_conflict policy %q. Must be one of %s, %s or %s",
+			o.LabelConflict, labelConflictError, labelConflictPrefix, labelConflictChildWins)
+	}
+	return o.Naming.validate()
+}
+
+// validate checks the namespace is a valid metric name and the case is known.
+func (n Naming) validate() error {
+	if n.Namespace != "" && !metricNameRegex.MatchString(n.Namespace) {
+		return fmt.Errorf("Invalid naming namespace %q. It must be a valid Prometheus metric name", n.Namespace)
+	}
+	switch n.Case {
+	case "", namingCaseSnake, namingCaseCamel:
+		return nil
+	}
+	return fmt.Errorf("Unknown naming case %q. Must be one of %s or %s", n.Case, namingCaseSnake, namingCaseCamel)
+}
+
+// convertCase converts an mBean attribute name to the configured case.
+func (n Naming) convertCase(name string) string {
+	if n.Case == namingCaseCamel {
+		return name
+	}
+	return strcase.ToSnake(name)
+}
+
+// withNamespace prepends the namespace to a metric name, if one is set.
+func (n Naming) withNamespace(name string) string {
+	if n.Namespace == "" {
+		return name
+	}
+	return n.Namespace + "_" + name
+}
+
+// beanMetricName names a metric after an mBean, such as a collection's size. It's prefixed with the namespace, or
+// weblogic if there's none.
+func (n Naming) beanMetricName(beanName, name string) string {
+	namespace := n.Namespace
+	if namespace == "" {
+		namespace = "weblogic"
+	}
+	return namespace + "_" + n.convertCase(beanName) + "_" + name
+}
+
+/*
+conventionSuffix returns the suffix an attribute should have under Prometheus naming conventions, along with the rest
+of its name and the factor its value is scaled by. Weblogic names cumulative counters like requestTotalCount, which
+become request_total and are exported as counters, and percentages like heapFreePercent, which become the
+heap_free_ratio between 0 and 1. An empty suffix means the attribute has no convention to apply.
+*/
+func (n Naming) conventionSuffix(fieldName string) (base, suffix string, scale float64) {
+	if !n.EnforceConventions {
+		return fieldName, "", 1
+	}
+	if base := strings.TrimSuffix(fieldName, "TotalCount"); base != fieldName && base != "" {
+		return base, "total", 1
+	}
+	if base := strings.TrimSuffix(fieldName, "Percent"); base != fieldName && base != "" {
+		return base, "ratio", 0.01
+	}
+	return fieldName, "", 1
+}
+
+// resolveLabelName returns the label name an mBean should use, given the label names already used by its ancestors
+// mapped to the mBean that uses each of them.
+func (o Options) resolveLabelName(beanName, labelName string, ancestorLabels map[string]string) (string, error) {
+	ancestor, conflict := ancestorLabels[labelName]
+	if labelName == "" || !conflict {
+		return labelName, nil
+	}
+	switch o.LabelConflict {
+	case labelConflictPrefix:
+		prefixed := strcase.ToSnake(beanName) + "_" + labelName
+		if ancestor, conflict := ancestorLabels[prefixed]; conflict {
+			return "", fmt.Errorf("Prefixed label_name %s of mBean %s is already used by its ancestor %s", prefixed, beanName, ancestor)
+		}
+		return prefixed, nil
+	case labelConflictChildWins:
+		return labelName, nil
+	}
+	return "", fmt.Errorf("label_name %s of mBean %s is already used by its ancestor %s. Use a different label_name or set label_conflict",
+		labelName, beanName, ancestor)
+}
@@ -0,0 +1,199 @@
+package exporter
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/benridley/wls_go/wls"
+)
+
+/*
+refreshGroup is a part of the mBean tree that's fetched with its own REST query. The root of the tree is always a
+group, and every mBean with a refresh_interval starts a new group, which excludes any nested groups. So the query of a
+group can reach its mBeans, it's wrapped in the group's ancestors, which only return their label attributes.
+*/
+type refreshGroup struct {
+	path     string           // Path of the mBean at the root of the group, such as serverRuntime/applicationRuntimes
+	interval time.Duration    // How long the group's response is cached for, 0 meaning it's fetched on every probe
+	query    wls.WLSRestQuery // The query sent to the WLS API to fetch the group
+	key      string           // Identifies the group's query in the response cache, so a changed query isn't answered from it
+}
+
+// refreshGroups splits the query into the groups that are fetched separately. wrap wraps a query of the mBean in the
+// mBean's ancestors.
+func (q *MbeanQuery) refreshGroups(path string, wrap func(wls.WLSRestQuery) wls.WLSRestQuery, groups []refreshGroup) []refreshGroup {
+	if wrap == nil || q.RefreshInterval > 0 {
+		query := q.restQuery(true)
+		if wrap != nil {
+			query = wrap(query)
+		}
+		// Marshalling a query can't fail, and sorts its children so the key is stable
+		queryJSON, _ := json.Marshal(query)
+		groups = append(groups, refreshGroup{path: path, interval: q.RefreshInterval, query: query, key: path + "\x00" + string(queryJSON)})
+	}
+
+	childNames := make([]string, 0, len(q.Children))
+	for childName := range q.Children {
+		childNames = append(childNames, childName)
+	}
+	sort.Strings(childNames)
+	for _, childName := range childNames {
+		childName := childName
+		child := q.Children[childName]
+		childWrap := func(childQuery wls.WLSRestQuery) wls.WLSRestQuery {
+			query := q.ancestorQuery(childName, childQuery)
+			if wrap != nil {
+				query = wrap(query)
+			}
+			return query
+		}
+		groups = child.refreshGroups(path+"/"+childName, childWrap, groups)
+	}
+	return groups
+}
+
+// ancestorQuery returns a query of the mBean that only fetches its label attribute and the given child.
+func (q *MbeanQuery) ancestorQuery(childName string, child wls.WLSRestQuery) wls.WLSRestQuery {
+	fields := []string{}
+	if q.LabelValueAttribute != "" {
+		fields = append(fields, q.LabelValueAttribute)
+	}
+	return wls.WLSRestQuery{
+		Fields:   fields,
+		Children: map[string]*wls.WLSRestQuery{childName: &child},
+		Links:    []string{},
+	}
+}
+
+// responseCache keeps the responses of refresh groups until their refresh interval expires.
+type responseCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResponse
+}
+
+type cachedResponse struct {
+	resp    *WeblogicAPIResponse
+	expires time.Time
+}
+
+func newResponseCache() *responseCache {
+	return &responseCache{entries: make(map[string]cachedResponse)}
+}
+
+// load returns the cached response of a group for a target, if it hasn't expired.
+func (c *responseCache) load(target, username, password string, group refreshGroup) (*WeblogicAPIResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey(target, username, password) + "\x00" + group.key
+	entry, ok := c.entries[key]
+	if !ok || !now().Before(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.resp, true
+}
+
+// store caches the response of a group for a target until its refresh interval expires, and drops expired entries.
+func (c *responseCache) store(target, username, password string, group refreshGroup, resp *WeblogicAPIResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if !now().Before(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	key := cacheKey(target, username, password) + "\x00" + group.key
+	c.entries[key] = cachedResponse{resp: resp, expires: now().Add(group.interval)}
+}
+
+/*
+ReuseResponseCache makes the exporter share the cached refresh group responses of old, the exporter it replaces when
+the config is reloaded, so they aren't fetched again until they expire. Responses are cached by query, so those of
+groups whose query changed aren't reused.
+*/
+func (e *Exporter) ReuseResponseCache(old *Exporter) {
+	e.responses = old.responses
+}
+
+/*
+mergeResponse merges the response of a refresh group into the response being built for a probe. Items of collections
+are matched on their label attribute, or their position if the collection has no label. Maps and items of dst are
+never shared with src, so cached responses aren't modified by later merges.
+*/
+func (cm MBeanConfigMap) mergeResponse(beanName string, dst, src *WeblogicAPIResponse) {
+	for key, value := range src.NumericalFields {
+		if dst.NumericalFields == nil {
+			dst.NumericalFields = make(map[string]float64)
+		}
+		dst.NumericalFields[key] = value
+	}
+	for key, value := range src.StringFields {
+		if dst.StringFields == nil {
+			dst.StringFields = make(map[string]string)
+		}
+		dst.StringFields[key] = value
+	}
+	for key, value := range src.ObjectFields {
+		if dst.ObjectFields == nil {
+			dst.ObjectFields = make(map[string]interface{})
+		}
+		dst.ObjectFields[key] = value
+	}
+	for key, value := range src.ArrayLengths {
+		if dst.ArrayLengths == nil {
+			dst.ArrayLengths = make(map[string]int)
+		}
+		dst.ArrayLengths[key] = value
+	}
+	for key := range src.NullFields {
+		if dst.NullFields == nil {
+			dst.NullFields = make(map[string]bool)
+		}
+		dst.NullFields[key] = true
+	}
+
+	if src.Items != nil {
+		if dst.Items == nil {
+			dst.Items = make([]*WeblogicAPIResponse, 0, len(src.Items))
+		}
+		labelAttribute := cm[beanName].LabelValueAttribute
+		for i, srcItem := range src.Items {
+			dstItem := matchItem(dst.Items, labelAttribute, srcItem, i)
+			if dstItem == nil {
+				dstItem = &WeblogicAPIResponse{}
+				dst.Items = append(dst.Items, dstItem)
+			}
+			cm.mergeResponse(beanName, dstItem, srcItem)
+		}
+	}
+
+	for childName, srcChild := range src.Children {
+		if dst.Children == nil {
+			dst.Children = make(map[string]*WeblogicAPIResponse)
+		}
+		dstChild, ok := dst.Children[childName]
+		if !ok {
+			dstChild = &WeblogicAPIResponse{}
+			dst.Children[childName] = dstChild
+		}
+		cm.mergeResponse(childName, dstChild, srcChild)
+	}
+}
+
+// matchItem finds the item of a collection with the same label value as item, or at the same index if there's no label.
+func matchItem(items []*WeblogicAPIResponse, labelAttribute string, item *WeblogicAPIResponse, index int) *WeblogicAPIResponse {
+	if labelValue, ok := item.StringFields[labelAttribute]; ok && labelAttribute != "" {
+		for _, candidate := range items {
+			if candidate.StringFields[labelAttribute] == labelValue {
+				return candidate
+			}
+		}
+		return nil
+	}
+	if index < len(items) {
+		return items[index]
+	}
+	return nil
+}
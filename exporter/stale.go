@@ -0,0 +1,66 @@
+package exporter
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+MetricCache keeps the last successful metrics of each target so they can be served for a while when the target can't
+be probed, such as during a long garbage collection pause. Entries are keyed by the credentials used as well as the
+target, so a probe with the wrong password can't read metrics fetched by someone else.
+*/
+type MetricCache struct {
+	mu      sync.Mutex
+	maxAge  time.Duration
+	entries map[string]cachedMetrics
+}
+
+type cachedMetrics struct {
+	metrics []prometheus.Gauge
+	time    time.Time
+}
+
+// NewMetricCache creates a cache that serves metrics for up to maxAge after they were fetched.
+func NewMetricCache(maxAge time.Duration) *MetricCache {
+	return &MetricCache{maxAge: maxAge, entries: make(map[string]cachedMetrics)}
+}
+
+// Store saves the metrics of a successful probe, replacing any earlier ones, and drops expired entries.
+func (c *MetricCache) Store(target, username, password string, metrics []prometheus.Gauge) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, entry := range c.entries {
+		if now().Sub(entry.time) > c.maxAge {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[cacheKey(target, username, password)] = cachedMetrics{metrics: metrics, time: now()}
+}
+
+// Load returns the last metrics stored for a target along with their age. It returns false if there are none or
+// they're older than the cache's max age.
+func (c *MetricCache) Load(target, username, password string) ([]prometheus.Gauge, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key := cacheKey(target, username, password)
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+	age := now().Sub(entry.time)
+	if age > c.maxAge {
+		delete(c.entries, key)
+		return nil, 0, false
+	}
+	return entry.metrics, age, true
+}
+
+// cacheKey identifies a target and the credentials used to probe it, without keeping the password in memory.
+func cacheKey(target, username, password string) string {
+	sum := sha256.Sum256([]byte(username + "\x00" + password))
+	return target + "\x00" + string(sum[:])
+}
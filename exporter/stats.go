@@ -0,0 +1,50 @@
+package exporter
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+ProbeStats describes a single probe of the Weblogic API so the exporter can report on itself.
+Each probe is broken into phases: the HTTP request to Weblogic, parsing its response, and building metrics from it.
+*/
+type ProbeStats struct {
+	RequestDuration time.Duration // Time spent sending the queries and reading the responses
+	ParseDuration   time.Duration // Time spent parsing the JSON responses and merging them
+	MetricsDuration time.Duration // Time spent converting the parsed response into metrics
+	ResponseSize    int           // Total size of the response bodies in bytes. Cached responses aren't counted
+	StatusCode      int           // HTTP status code last returned by the Weblogic API, 0 if no response was received
+	Series          int           // Number of series generated from the response
+}
+
+// Gauges converts the probe's stats into metrics to be returned alongside the probe's results.
+func (s *ProbeStats) Gauges() []prometheus.Gauge {
+	newGauge := func(name, help string, labels prometheus.Labels, value float64) prometheus.Gauge {
+		gauge := prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        name,
+			Help:        help,
+			ConstLabels: labels,
+		})
+		gauge.Set(value)
+		return gauge
+	}
+
+	phases := map[string]time.Duration{
+		"request": s.RequestDuration,
+		"parse":   s.ParseDuration,
+		"metrics": s.MetricsDuration,
+	}
+	gauges := make([]prometheus.Gauge, 0, len(phases)+3)
+	for phase, duration := range phases {
+		gauges = append(gauges, newGauge("weblogic_probe_duration_seconds", "Time taken by each phase of the probe",
+			prometheus.Labels{"phase": phase}, duration.Seconds()))
+	}
+	gauges = append(gauges,
+		newGauge("weblogic_probe_response_size_bytes", "Size of the Weblogic API response", nil, float64(s.ResponseSize)),
+		newGauge("weblogic_probe_http_status_code", "HTTP status code returned by the Weblogic API", nil, float64(s.StatusCode)),
+		newGauge("weblogic_probe_series", "Number of series generated from the Weblogic API response", nil, float64(s.Series)),
+	)
+	return gauges
+}
@@ -0,0 +1,62 @@
+package exporter
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Policies for string field values that aren't in the field's value_set, set with unknown_values in config.
+const (
+	unknownValuesIgnore = "ignore" // Skip the value, leaving every state series at 0. This is the default
+	unknownValuesOther  = "other"  // Always export an "other" state series, set to 1 when the value is unknown
+	unknownValuesLabel  = "label"  // Export an extra series with the unknown value as its label
+	unknownValuesCount  = "count"  // Count the value in UnknownStringValues and log it the first time it's seen
+)
+
+// UnknownStringValues counts string field values returned by Weblogic that weren't in the field's value_set,
+// for fields using the count policy. It should be registered with the exporter's own registry.
+var UnknownStringValues = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "weblogic_exporter_unknown_string_values_total",
+	Help: "Number of string field values returned by Weblogic that weren't in the configured value_set",
+}, []string{"mbean", "field"})
+
+// loggedUnknownValues records which unknown string values have been logged, so each is only logged once.
+var loggedUnknownValues = struct {
+	sync.Mutex
+	seen map[string]bool
+}{seen: make(map[string]bool)}
+
+// validateUnknownValuesPolicy checks the unknown_values policy of a string field.
+func validateUnknownValuesPolicy(beanName string, field StringField) error {
+	switch field.UnknownValues {
+	case unknownValuesOther:
+		// The other series would duplicate the series of the known value
+		for _, value := range field.ValueSet {
+			if value == otherLabelValue {
+				return fmt.Errorf("String field %s on mBean %s can't use unknown_values: %s, as its value_set already contains %q",
+					field.Name, beanName, unknownValuesOther, otherLabelValue)
+			}
+		}
+		return nil
+	case "", unknownValuesIgnore, unknownValuesLabel, unknownValuesCount:
+		return nil
+	}
+	return fmt.Errorf("Unknown unknown_values policy %q for string field %s on mBean %s. Must be one of %s, %s, %s or %s",
+		field.UnknownValues, field.Name, beanName, unknownValuesIgnore, unknownValuesOther, unknownValuesLabel, unknownValuesCount)
+}
+
+// countUnknownStringValue counts a string field value that wasn't in its value_set, logging it the first time it's seen.
+func countUnknownStringValue(beanName, fieldName, value string) {
+	UnknownStringValues.WithLabelValues(beanName, fieldName).Inc()
+
+	key := beanName + "/" + fieldName + "/" + value
+	loggedUnknownValues.Lock()
+	defer loggedUnknownValues.Unlock()
+	if !loggedUnknownValues.seen[key] {
+		loggedUnknownValues.seen[key] = true
+		log.Printf("mBean %s returned value %q for string field %s, which isn't in its value_set", beanName, value, fieldName)
+	}
+}
@@ -5,5 +5,7 @@ go 1.14
 require (
 	github.com/iancoleman/strcase v0.0.0-20191112232945-16388991a334
 	github.com/prometheus/client_golang v1.5.1
+	github.com/prometheus/client_model v0.2.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	gopkg.in/yaml.v2 v2.2.8
 )
@@ -21,7 +21,7 @@ A Prometheus exporter for Oracle Weblogic Server.
 %setup -q %{SOURCE0} -n weblogic_exporter-%{version}
 
 %build
-go build -o bin/weblogic_exporter -mod vendor src/main.go
+go build -ldflags "-X main.version=%{version}" -o bin/weblogic_exporter -mod vendor src/main.go
 
 %install
 mkdir -p %{buildroot}/opt/weblogic_exporter
@@ -1,26 +1,32 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"runtime"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/benridley/wls_go/config"
+	"github.com/benridley/wls_go/discover"
 	"github.com/benridley/wls_go/exporter"
+	"github.com/benridley/wls_go/web"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"gopkg.in/yaml.v2"
 )
 
-// Config represents the main application config
-type Config struct {
-	CertPath   string              `yaml:"tls_cert_path"` // Certificate used for TLS, should include CA chain if its signed.
-	Keypath    string              `yaml:"tls_key_path"`  // Private Key used for TLS
-	ListenPort string              `yaml:"listen_port"`   // Port used to listen for scrape requests
-	Queries    exporter.MbeanQuery `yaml:"queries"`       // Queries of mBeans the exporter tries to scrape
-}
-
 // errorRegistry stores the number of seen errors for a host/port combo.
 // On a successful scrape, the entry is deleted. Errors will be logged
 // up to 3 times before no longer logging.
@@ -29,42 +35,330 @@ var errorRegistry = make(map[string]int)
 // Number of times to log an error between successful scrapes.
 const errLogCount = 10
 
+// version is the exporter's version, set at build time with -ldflags "-X main.version=<version>".
+var version = "dev"
+
+// Metrics about the exporter itself, served on /metrics alongside the Go and process collectors.
+var (
+	buildInfo = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "weblogic_exporter_build_info",
+		Help: "A metric with a constant '1' value labelled by the version and Go version the exporter was built with",
+	}, []string{"version", "goversion"})
+	probesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "weblogic_exporter_probes_total",
+		Help: "Total number of probes for each target, by result",
+	}, []string{"target", "result"})
+	probeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "weblogic_exporter_probe_duration_seconds",
+		Help:    "Time taken to probe each target",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"target"})
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "weblogic_exporter_config_last_reload_successful",
+		Help: "Whether the last attempt to reload the config was successful",
+	})
+	configReloadTime = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "weblogic_exporter_config_last_reload_success_timestamp_seconds",
+		Help: "Timestamp of the last successful config load",
+	})
+)
+
+func init() {
+	buildInfo.WithLabelValues(version, runtime.Version()).Set(1)
+	prometheus.MustRegister(buildInfo, probesTotal, probeDuration, configReloadSuccess, configReloadTime,
+		exporter.UnknownStringValues, exporter.ItemsDropped, exporter.DuplicateSeriesDropped)
+}
+
 func main() {
 	configPath := flag.String("config-file", "config.yaml", "Configuration file path")
-	flag.Parse()
-
-	configBytes, err := ioutil.ReadFile(*configPath)
-	if err != nil {
-		log.Fatalf("Failed to read config file: %s", err.Error())
+	checkConfig := flag.Bool("check-config", false, "Check the configuration file, print the REST query it produces and exit")
+	command, args := "", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
 	}
 
-	config := Config{}
-	err = yaml.Unmarshal(configBytes, &config)
-	if err != nil {
-		log.Fatal(err)
+	switch command {
+	case "":
+	case "check":
+		*checkConfig = true
+	case "convert":
+		if err := convertConfig(args); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	case "discover":
+		if err := discoverConfig(args); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
+	default:
+		log.Fatalf("Unknown command %q. Must be one of check, convert or discover", command)
 	}
+	flag.CommandLine.Parse(args)
 
-	if config.ListenPort == "" {
-		config.ListenPort = "9325"
+	if *checkConfig {
+		if err := check(*configPath); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
 	}
 
-	exporter, err := exporter.New(config.Queries)
+	conf, err := config.Load(*configPath)
 	if err != nil {
+		log.Fatal(err)
+	}
+	r := &reloader{configPath: *configPath}
+	if err := r.apply(conf); err != nil {
 		log.Fatalf("Unable to start exporter: %s", err.Error())
 	}
+	configReloadSuccess.Set(1)
+	configReloadTime.SetToCurrentTime()
+	// Signals are registered before serving, so a SIGHUP sent once the exporter is up can't kill it
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go r.reloadOnSIGHUP(hup)
 
 	http.HandleFunc("/probe", func(resp http.ResponseWriter, req *http.Request) {
-		probeHandler(resp, req, &exporter)
+		probeHandler(resp, req, r.current())
 	})
+	http.HandleFunc("/-/reload", r.handleReload)
+	http.Handle("/metrics", promhttp.Handler())
 
-	if config.CertPath != "" {
-		log.Fatal(http.ListenAndServeTLS(":"+config.ListenPort, config.CertPath, config.Keypath, nil))
+	tlsConfig, err := conf.Web.TLSConfig()
+	if err != nil {
+		log.Fatalf("Unable to start exporter: %s", err.Error())
+	}
+	server := &http.Server{
+		Addr:      ":" + conf.ListenPort,
+		Handler:   web.Handler(func() web.Config { return r.current().web }, http.DefaultServeMux),
+		TLSConfig: tlsConfig,
+	}
+	if conf.CertPath != "" {
+		log.Fatal(server.ListenAndServeTLS(conf.CertPath, conf.Keypath))
 	} else {
-		log.Fatal(http.ListenAndServe(":"+config.ListenPort, nil))
+		log.Fatal(server.ListenAndServe())
+	}
+}
+
+// prober holds everything built from the config that's needed to probe targets, so it can be replaced as a whole.
+type prober struct {
+	exporter *exporter.Exporter
+	cache    *exporter.MetricCache // Last good metrics of each target, nil if stale_max_age isn't set
+	web      web.Config            // Authentication of requests to the exporter
+	username string                // Weblogic credentials used instead of the request's, if set
+	password string
+}
+
+/*
+reloader re-reads the config file on SIGHUP or a POST to /-/reload. The new config is built off to the side and only
+swapped in if it's valid, so probes in flight finish with the old config and a broken config leaves the old one active.
+*/
+type reloader struct {
+	configPath string
+	mu         sync.Mutex     // Prevents reloads running concurrently
+	conf       *config.Config // The active config
+	prober     atomic.Value   // The active *prober
+}
+
+// current returns the prober built from the active config.
+func (r *reloader) current() *prober {
+	return r.prober.Load().(*prober)
+}
+
+/*
+apply builds a prober from the config and makes it active. The active config is left untouched if it's invalid. On a
+reload, the caches of the active prober are kept so a reload during a Weblogic outage doesn't lose them: responses of
+refresh groups whose query is unchanged, and last good metrics if stale_max_age is unchanged.
+*/
+func (r *reloader) apply(conf *config.Config) error {
+	e, err := exporter.New(*conf.MBeans, conf.Options)
+	if err != nil {
+		return err
+	}
+	for _, warning := range conf.Warnings {
+		log.Printf("Warning: %s", warning)
+	}
+	p := &prober{exporter: &e, web: conf.Web, username: conf.WeblogicUsername, password: conf.WeblogicPassword}
+	var old *prober
+	if r.conf != nil {
+		old = r.current()
+		e.ReuseResponseCache(old.exporter)
+	}
+	if old != nil && old.cache != nil && conf.StaleMaxAge == r.conf.StaleMaxAge {
+		p.cache = old.cache
+	} else if conf.StaleMaxAge > 0 {
+		p.cache = exporter.NewMetricCache(conf.StaleMaxAge)
+	}
+	r.conf = conf
+	r.prober.Store(p)
+	return nil
+}
+
+// reload re-reads the config file, recording the outcome in the config reload metrics.
+func (r *reloader) reload() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.reloadConfig()
+	if err != nil {
+		configReloadSuccess.Set(0)
+		return err
+	}
+	configReloadSuccess.Set(1)
+	configReloadTime.SetToCurrentTime()
+	return nil
+}
+
+func (r *reloader) reloadConfig() error {
+	conf, err := config.Load(r.configPath)
+	if err != nil {
+		return err
+	}
+	old := r.conf
+	if err := r.apply(conf); err != nil {
+		return fmt.Errorf("Invalid config file %s: %s", r.configPath, err.Error())
+	}
+	if conf.ListenPort != old.ListenPort || conf.CertPath != old.CertPath || conf.Keypath != old.Keypath ||
+		conf.Web.ClientCAFile != old.Web.ClientCAFile {
+		log.Printf("Config reloaded, but changes to listen_port, tls_cert_path, tls_key_path and web client_ca_file need a restart to apply")
+	}
+	return nil
+}
+
+// reloadOnSIGHUP reloads the config whenever a SIGHUP is received on hup.
+func (r *reloader) reloadOnSIGHUP(hup <-chan os.Signal) {
+	for range hup {
+		if err := r.reload(); err != nil {
+			log.Printf("Failed to reload config, keeping the previous config: %s", err.Error())
+		} else {
+			log.Printf("Reloaded config from %s", r.configPath)
+		}
+	}
+}
+
+// handleReload reloads the config on a POST request, responding with the error if it fails.
+func (r *reloader) handleReload(resp http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(resp, "Only POST requests are allowed.", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.reload(); err != nil {
+		log.Printf("Failed to reload config, keeping the previous config: %s", err.Error())
+		http.Error(resp, fmt.Sprintf("Failed to reload config: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Reloaded config from %s", r.configPath)
+}
+
+// check validates the config file the same way as on startup, and prints the REST query it produces.
+func check(configPath string) error {
+	conf, err := config.Load(configPath)
+	if err != nil {
+		return err
+	}
+	e, err := exporter.New(*conf.MBeans, conf.Options)
+	if err != nil {
+		return fmt.Errorf("Invalid config file %s: %s", configPath, err.Error())
+	}
+	queryJSON, err := e.GetRESTQueryJSON()
+	if err != nil {
+		return err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, queryJSON, "", "  "); err != nil {
+		return err
 	}
+	for _, warning := range conf.Warnings {
+		fmt.Printf("Warning: %s\n", warning)
+	}
+	fmt.Printf("Config file %s is valid. It produces the REST query:\n%s\n", configPath, indented.String())
+	return nil
 }
 
-func probeHandler(resp http.ResponseWriter, req *http.Request, e *exporter.Exporter) {
+// convertConfig converts a config written for Oracle's WebLogic Monitoring Exporter into this exporter's format.
+func convertConfig(args []string) error {
+	flags := flag.NewFlagSet("convert", flag.ExitOnError)
+	input := flags.String("input", "", "Config file written for Oracle's WebLogic Monitoring Exporter")
+	output := flags.String("output", "", "File to write the converted config to. Defaults to stdout")
+	flags.Parse(args)
+	if *input == "" {
+		return fmt.Errorf("Provide the config file to convert with --input")
+	}
+
+	data, err := ioutil.ReadFile(*input)
+	if err != nil {
+		return fmt.Errorf("Failed to read config file: %s", err.Error())
+	}
+	conf, err := config.ConvertOracle(data)
+	if err != nil {
+		return fmt.Errorf("Cannot convert config file %s: %s", *input, err.Error())
+	}
+	if _, err := exporter.New(*conf.MBeans, conf.Options); err != nil {
+		return fmt.Errorf("Config file %s converts to an invalid config: %s", *input, err.Error())
+	}
+	converted, err := yaml.Marshal(conf)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# Converted from %s, written for Oracle's WebLogic Monitoring Exporter.\n", *input)
+	for _, warning := range conf.Warnings {
+		fmt.Fprintf(&buf, "# Warning: %s\n", warning)
+	}
+	buf.Write(converted)
+	if *output == "" {
+		_, err = os.Stdout.Write(buf.Bytes())
+		return err
+	}
+	return ioutil.WriteFile(*output, buf.Bytes(), 0644)
+}
+
+// discoverConfig walks the mBean tree of a Weblogic server and writes a starter config for it.
+func discoverConfig(args []string) error {
+	flags := flag.NewFlagSet("discover", flag.ExitOnError)
+	host := flags.String("host", "localhost", "Host of the Weblogic server")
+	port := flags.Int("port", 7001, "Port of the Weblogic server")
+	username := flags.String("username", "", "Username to log in to Weblogic with")
+	depth := flags.Int("depth", 2, "How many levels of children below serverRuntime to discover")
+	output := flags.String("output", "", "File to write the config to. Defaults to stdout")
+	flags.Parse(args)
+
+	// The password is read from the environment so it doesn't show up in the process list
+	password := os.Getenv("WEBLOGIC_PASSWORD")
+	if *username == "" || password == "" {
+		return fmt.Errorf("Provide a username with --username and a password in the WEBLOGIC_PASSWORD environment variable")
+	}
+
+	walker := discover.Walker{
+		Client:   &http.Client{Timeout: 30 * time.Second},
+		BaseURL:  fmt.Sprintf("http://%s:%d", *host, *port),
+		Username: *username,
+		Password: password,
+		Depth:    *depth,
+	}
+	root, err := walker.Walk()
+	if err != nil {
+		return fmt.Errorf("Failed to discover mBeans: %s", err.Error())
+	}
+
+	out := os.Stdout
+	if *output != "" {
+		out, err = os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	return root.WriteYAML(out, fmt.Sprintf("%s:%d", *host, *port))
+}
+
+// probeHandler probes the target given in the request, with the prober's Weblogic credentials if it has them or else
+// the request's. If the prober has a cache, the target's last good metrics are served when the probe fails.
+func probeHandler(resp http.ResponseWriter, req *http.Request, p *prober) {
+	e, cache := p.exporter, p.cache
 	params := req.URL.Query()
 	host := params.Get("host")
 	port := params.Get("port")
@@ -78,7 +372,10 @@ func probeHandler(resp http.ResponseWriter, req *http.Request, e *exporter.Expor
 		return
 	}
 
-	username, password, ok := req.BasicAuth()
+	username, password, ok := p.username, p.password, p.username != ""
+	if !ok {
+		username, password, ok = req.BasicAuth()
+	}
 	if !ok {
 		http.Error(resp, "Missing authentication information. Please provide basic authentication credentials.", 400)
 		return
@@ -88,32 +385,57 @@ func probeHandler(resp http.ResponseWriter, req *http.Request, e *exporter.Expor
 		Name: "weblogic_probe_success",
 		Help: "Displays whether or not the probe was a success",
 	})
+	dataAgeGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "weblogic_probe_data_age_seconds",
+		Help: "Age of the metrics served. Non-zero when the probe failed and the last good metrics are served instead",
+	})
 	registry := prometheus.NewRegistry()
-	metrics, err := e.DoQuery(host, portInt, username, password)
+	target := host + ":" + port
+	start := time.Now()
+	metrics, stats, err := e.DoQuery(host, portInt, username, password)
+	probeDuration.WithLabelValues(target).Observe(time.Since(start).Seconds())
+	for _, metric := range stats.Gauges() {
+		registry.MustRegister(metric)
+	}
 	if err != nil {
+		probesTotal.WithLabelValues(target, "failure").Inc()
 		probeSuccessGauge.Set(0)
 		// Check if we've seen this error already while failing scrapes. If not, log it.
 		if numErrs, ok := errorRegistry[(host + port)]; ok {
-			if numErrs < errLogCount  {
+			if numErrs < errLogCount {
 				log.Printf("Failed to probe weblogic instance %s:%s: %v", host, port, err.Error())
-				errorRegistry[host + port]++
-				if errorRegistry[host + port] == errLogCount {
-					log.Printf("Pausing logging of errors until a successful scrape occurs on %s:%s...", host, port)	
+				errorRegistry[host+port]++
+				if errorRegistry[host+port] == errLogCount {
+					log.Printf("Pausing logging of errors until a successful scrape occurs on %s:%s...", host, port)
 				}
 			}
 		} else {
 			// No errors seen yet
 			log.Printf("Failed to probe weblogic instance %s:%s: %v", host, port, err.Error())
-			errorRegistry[host + port] = 1 
+			errorRegistry[host+port] = 1
 		}
 		registry.MustRegister(probeSuccessGauge)
+		if cache != nil {
+			if staleMetrics, age, ok := cache.Load(target, username, password); ok {
+				dataAgeGauge.Set(age.Seconds())
+				registry.MustRegister(dataAgeGauge)
+				for _, metric := range staleMetrics {
+					registry.MustRegister(metric)
+				}
+			}
+		}
 	} else {
+		probesTotal.WithLabelValues(target, "success").Inc()
 		delete(errorRegistry, (host + port))
 		probeSuccessGauge.Set(1)
 		registry.MustRegister(probeSuccessGauge)
 		for _, metric := range metrics {
 			registry.MustRegister(metric)
 		}
+		if cache != nil {
+			cache.Store(target, username, password, metrics)
+			registry.MustRegister(dataAgeGauge)
+		}
 	}
 
 	h := promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/benridley/wls_go/config"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	validConfig   = "mbeans:\n  label_name: server\n  label_value_attribute: name\n  fields: [openSocketsCurrentCount]\n"
+	invalidConfig = "mbeans:\n  label_name: server\n  max_items: -1\n  fields: [openSocketsCurrentCount]\n"
+)
+
+// newTestReloader starts a reloader from a config file in a temporary directory, returning the file's path.
+func newTestReloader(t *testing.T) (*reloader, string, func()) {
+	dir, err := ioutil.TempDir("", "wls_go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "config.yaml")
+	writeTestConfig(t, path, "stale_max_age: 5m\n"+validConfig)
+	conf, err := config.Load(path)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	r := &reloader{configPath: path}
+	if err := r.apply(conf); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return r, path, func() { os.RemoveAll(dir) }
+}
+
+func writeTestConfig(t *testing.T, path, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func gaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	var m dto.Metric
+	if err := gauge.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetGauge().GetValue()
+}
+
+func TestReload(t *testing.T) {
+	r, path, cleanup := newTestReloader(t)
+	defer cleanup()
+
+	// A broken config leaves the previous one active
+	oldConf, oldProber := r.conf, r.current()
+	writeTestConfig(t, path, invalidConfig)
+	if err := r.reload(); err == nil {
+		t.Error("Expected an error reloading an invalid config")
+	}
+	if r.conf != oldConf || r.current() != oldProber {
+		t.Error("Want the previous config kept after a failed reload")
+	}
+	if gaugeValue(t, configReloadSuccess) != 0 {
+		t.Error("Want the reload recorded as failed")
+	}
+
+	// The last good metrics of targets are kept while stale_max_age is unchanged
+	writeTestConfig(t, path, "stale_max_age: 5m\n"+validConfig)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if r.current() == oldProber || r.current().cache != oldProber.cache {
+		t.Error("Want a new prober sharing the previous metric cache")
+	}
+	if gaugeValue(t, configReloadSuccess) != 1 {
+		t.Error("Want the reload recorded as successful")
+	}
+	writeTestConfig(t, path, "stale_max_age: 10m\n"+validConfig)
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+	if r.current().cache == oldProber.cache {
+		t.Error("Want a new metric cache after stale_max_age changed")
+	}
+}
+
+func TestHandleReload(t *testing.T) {
+	r, _, cleanup := newTestReloader(t)
+	defer cleanup()
+	oldProber := r.current()
+	for _, tc := range []struct {
+		method     string
+		wantStatus int
+		reloaded   bool
+	}{
+		{method: http.MethodGet, wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodPut, wantStatus: http.StatusMethodNotAllowed},
+		{method: http.MethodPost, wantStatus: http.StatusOK, reloaded: true},
+	} {
+		resp := httptest.NewRecorder()
+		r.handleReload(resp, httptest.NewRequest(tc.method, "/-/reload", nil))
+		if resp.Code != tc.wantStatus {
+			t.Errorf("%s: Want status %d, got %d", tc.method, tc.wantStatus, resp.Code)
+		}
+		if reloaded := r.current() != oldProber; reloaded != tc.reloaded {
+			t.Errorf("%s: Want reloaded %t, got %t", tc.method, tc.reloaded, reloaded)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Package web authenticates requests to the exporter's own listener, with basic auth, bearer tokens or client
+certificates, so the exporter can't be used by anyone who can reach its port.
+*/
+package web
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// dummyHash is compared against the password of unknown users, so the time taken doesn't reveal which usernames exist.
+// It's a hash at bcrypt's default cost, which is what hashes of real users usually use.
+const dummyHash = "$2a$10$qZcTr7WItZWN8AOvUz99VOdM6Jfwg6RhhpxFXf3UN0tLc.EOZg1iK"
+
+// Config configures how requests to the exporter are authenticated. Requests are allowed if they match any of the
+// basic auth users or bearer tokens, or all requests if neither is set.
+type Config struct {
+	BasicAuthUsers map[string]string `yaml:"basic_auth_users,omitempty"` // Usernames mapped to bcrypt hashes of their passwords
+	BearerTokens   []string          `yaml:"bearer_tokens,omitempty"`    // Tokens accepted in Authorization: Bearer headers
+	ClientCAFile   string            `yaml:"client_ca_file,omitempty"`   // CA certificates that client certificates must be signed by
+}
+
+// Validate checks the passwords are bcrypt hashes and the tokens aren't empty.
+func (c Config) Validate() error {
+	for username, hash := range c.BasicAuthUsers {
+		if username == "" {
+			return errors.New("Basic auth usernames must not be empty")
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("Password of basic auth user %s must be a bcrypt hash: %s", username, err.Error())
+		}
+	}
+	for _, token := range c.BearerTokens {
+		if token == "" {
+			return errors.New("Bearer tokens must not be empty")
+		}
+	}
+	return nil
+}
+
+// AuthEnabled reports whether requests must carry credentials in their Authorization header.
+func (c Config) AuthEnabled() bool {
+	return len(c.BasicAuthUsers) != 0 || len(c.BearerTokens) != 0
+}
+
+// Authenticate reports whether the request has valid credentials, or true if authentication isn't enabled.
+func (c Config) Authenticate(req *http.Request) bool {
+	if !c.AuthEnabled() {
+		return true
+	}
+	header := req.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		token := []byte(strings.TrimPrefix(header, "Bearer "))
+		valid := false
+		// Every token is compared so the time taken doesn't reveal which one matched
+		for _, t := range c.BearerTokens {
+			if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+				valid = true
+			}
+		}
+		return valid
+	}
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		return false
+	}
+	hash, ok := c.BasicAuthUsers[username]
+	if !ok {
+		bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Handler wraps a handler, rejecting requests that fail authentication. The handler to use is looked up per request
+// so the config can be reloaded.
+func Handler(config func() Config, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		c := config()
+		if !c.Authenticate(req) {
+			if len(c.BasicAuthUsers) != 0 {
+				resp.Header().Set("WWW-Authenticate", `Basic realm="weblogic_exporter"`)
+			}
+			http.Error(resp, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// TLSConfig returns the TLS config of the listener, which requires client certificates signed by the client CA if one
+// is set. It returns nil if there's no client CA.
+func (c Config) TLSConfig() (*tls.Config, error) {
+	if c.ClientCAFile == "" {
+		return nil, nil
+	}
+	caPEM, err := ioutil.ReadFile(c.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read client CA file: %s", err.Error())
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("No certificates found in client CA file %s", c.ClientCAFile)
+	}
+	return &tls.Config{ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}, nil
+}
@@ -0,0 +1,144 @@
+package web
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestHandler(t *testing.T) {
+	config := Config{
+		// Hash of "secret" at the minimum cost, to keep the test fast
+		BasicAuthUsers: map[string]string{"prometheus": "$2a$04$MdS8UvacUq7TMZ/LljcVkebu5arr3NNJb2f1blCTnJGDZyaGTFY.."},
+		BearerTokens:   []string{"abc"},
+	}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+	handler := Handler(func() Config { return config }, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {}))
+
+	for _, tc := range []struct {
+		name       string
+		setAuth    func(req *http.Request)
+		wantStatus int
+	}{
+		{name: "basic auth", setAuth: func(req *http.Request) { req.SetBasicAuth("prometheus", "secret") }, wantStatus: http.StatusOK},
+		{name: "wrong password", setAuth: func(req *http.Request) { req.SetBasicAuth("prometheus", "wrong") }, wantStatus: http.StatusUnauthorized},
+		{name: "unknown user", setAuth: func(req *http.Request) { req.SetBasicAuth("other", "secret") }, wantStatus: http.StatusUnauthorized},
+		{name: "bearer token", setAuth: func(req *http.Request) { req.Header.Set("Authorization", "Bearer abc") }, wantStatus: http.StatusOK},
+		{name: "wrong token", setAuth: func(req *http.Request) { req.Header.Set("Authorization", "Bearer abd") }, wantStatus: http.StatusUnauthorized},
+		{name: "no credentials", setAuth: func(req *http.Request) {}, wantStatus: http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest("GET", "/probe", nil)
+		tc.setAuth(req)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tc.wantStatus {
+			t.Errorf("%s: Want status %d, got %d", tc.name, tc.wantStatus, rec.Code)
+		}
+		if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: Want WWW-Authenticate header on unauthorized response", tc.name)
+		}
+	}
+
+	// Without users or tokens every request is allowed
+	rec := httptest.NewRecorder()
+	Handler(func() Config { return Config{} }, http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {})).
+		ServeHTTP(rec, httptest.NewRequest("GET", "/probe", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("No authentication: Want status %d, got %d", http.StatusOK, rec.Code)
+	}
+
+	// Unknown users are checked against a dummy hash that takes as long as a real one
+	if cost, err := bcrypt.Cost([]byte(dummyHash)); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("Want a dummy hash at the default cost, got cost %d and error %v", cost, err)
+	}
+}
+
+// newTestCert creates a certificate signed by parent, or a self-signed CA if parent is nil.
+func newTestCert(t *testing.T, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func TestClientCertificates(t *testing.T) {
+	ca := newTestCert(t, "client CA", nil)
+	otherCA := newTestCert(t, "other CA", nil)
+	caFile, err := ioutil.TempFile("", "client_ca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(caFile.Name())
+	if err := pem.Encode(caFile, &pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate[0]}); err != nil {
+		t.Fatal(err)
+	}
+	caFile.Close()
+
+	tlsConfig, err := Config{ClientCAFile: caFile.Name()}.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {}))
+	server.TLS = tlsConfig
+	server.StartTLS()
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name      string
+		certs     []tls.Certificate
+		wantError bool
+	}{
+		{name: "no certificate", wantError: true},
+		{name: "untrusted certificate", certs: []tls.Certificate{newTestCert(t, "client", &otherCA)}, wantError: true},
+		{name: "trusted certificate", certs: []tls.Certificate{newTestCert(t, "client", &ca)}},
+	} {
+		client := server.Client()
+		client.Transport.(*http.Transport).TLSClientConfig.Certificates = tc.certs
+		resp, err := client.Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		if (err != nil) != tc.wantError {
+			t.Errorf("%s: Want error %t, got %v", tc.name, tc.wantError, err)
+		}
+	}
+}
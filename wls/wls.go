@@ -1,9 +1,27 @@
 package wls
 
+import "encoding/json"
+
 type WLSRestQuery struct {
 	Fields   []string                 `json:"fields"`
 	Children map[string]*WLSRestQuery `json:"children,omitempty"`
 	Links    []string                 `json:"links"`
 }
 
+// MarshalJSON implements the json.Marshaler interface for WLSRestQuery. A nil Fields slice is left out of the query
+// so the WLS API returns every attribute, whereas an empty one is sent as is so that it returns none.
+func (q WLSRestQuery) MarshalJSON() ([]byte, error) {
+	type restQuery WLSRestQuery
+	if q.Fields != nil {
+		return json.Marshal(restQuery(q))
+	}
+	return json.Marshal(struct {
+		Children map[string]*WLSRestQuery `json:"children,omitempty"`
+		Links    []string                 `json:"links"`
+	}{
+		Children: q.Children,
+		Links:    q.Links,
+	})
+}
+
 type WLSRawResponse = map[string]interface{}